@@ -0,0 +1,116 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+// expandArgs replaces placeholders in the arguments of repo's command:
+// {repo} is its path from the root, {name} its directory name and {branch}
+// its current branch. {{ stands for a literal {. Any other braces, such as
+// those of @{upstream}, are left alone.
+func expandArgs(args []string, repo string) []string {
+	expanded := make([]string, len(args))
+	for i, arg := range args {
+		expanded[i] = expandArg(arg, repo)
+	}
+	return expanded
+}
+
+func expandArg(arg string, repo string) string {
+	if !strings.Contains(arg, "{") {
+		return arg
+	}
+
+	var b strings.Builder
+	for rest := arg; rest != ""; {
+		start := strings.Index(rest, "{")
+		if start < 0 {
+			b.WriteString(rest)
+			break
+		}
+		b.WriteString(rest[:start])
+		rest = rest[start:]
+
+		if strings.HasPrefix(rest, "{{") {
+			b.WriteString("{")
+			rest = rest[2:]
+			continue
+		}
+		end := strings.Index(rest, "}")
+		if end < 0 {
+			b.WriteString(rest)
+			break
+		}
+		switch rest[1:end] {
+		case "repo":
+			b.WriteString(filepath.ToSlash(repo))
+		case "name":
+			b.WriteString(filepath.Base(repo))
+		case "branch":
+			b.WriteString(displayBranch(repoDir(repo)))
+		default:
+			b.WriteString(rest[:end+1])
+		}
+		rest = rest[end+1:]
+	}
+	return b.String()
+}
+
+// splitWords splits a -cmd value into arguments the way a POSIX shell
+// would, without expanding anything: words are separated by whitespace,
+// single quotes keep everything up to the next one, double quotes keep
+// everything but a backslash escaping ", \, $ or `, and a backslash
+// outside quotes keeps the next character.
+func splitWords(s string) ([]string, error) {
+	words := []string{}
+	var word strings.Builder
+	inWord := false
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n':
+			if inWord {
+				words = append(words, word.String())
+				word.Reset()
+				inWord = false
+			}
+		case c == '\'':
+			end := strings.IndexByte(s[i+1:], '\'')
+			if end < 0 {
+				return nil, fmt.Errorf("unterminated ' in %q", s)
+			}
+			word.WriteString(s[i+1 : i+1+end])
+			i += end + 1
+			inWord = true
+		case c == '"':
+			closed := false
+			for i++; i < len(s); i++ {
+				if s[i] == '"' {
+					closed = true
+					break
+				}
+				if s[i] == '\\' && i+1 < len(s) && strings.IndexByte("\"\\$`", s[i+1]) >= 0 {
+					i++
+				}
+				word.WriteByte(s[i])
+			}
+			if !closed {
+				return nil, fmt.Errorf("unterminated \" in %q", s)
+			}
+			inWord = true
+		case c == '\\' && i+1 < len(s):
+			i++
+			word.WriteByte(s[i])
+			inWord = true
+		default:
+			word.WriteByte(c)
+			inWord = true
+		}
+	}
+	if inWord {
+		words = append(words, word.String())
+	}
+	return words, nil
+}
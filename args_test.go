@@ -0,0 +1,82 @@
+package main
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestExpandArg(t *testing.T) {
+	defer func(saved string) { rootDir = saved }(rootDir)
+	rootDir = t.TempDir()
+	newTestRepo(t, filepath.Join(rootDir, "team", "api"))
+	runGit(t, filepath.Join(rootDir, "team", "api"), "checkout", "-q", "-b", "feature")
+	defer branches.reset()
+
+	tests := []struct {
+		arg  string
+		want string
+	}{
+		{"push", "push"},
+		{"{repo}", "team/api"},
+		{"{name}", "api"},
+		{"origin/{branch}", "origin/feature"},
+		{"release-{name}-{branch}", "release-api-feature"},
+		{"{{repo}", "{repo}"},
+		{"{{{name}}", "{api}"},
+		{"@{upstream}", "@{upstream}"},
+		{"{unknown}", "{unknown}"},
+		{"unclosed {repo", "unclosed {repo"},
+		{"{}", "{}"},
+	}
+	for _, test := range tests {
+		if got := expandArg(test.arg, "team/api"); got != test.want {
+			t.Errorf("expandArg(%q) = %q, want %q", test.arg, got, test.want)
+		}
+	}
+}
+
+func TestExpandArgsUnknownBranch(t *testing.T) {
+	defer func(saved string) { rootDir = saved }(rootDir)
+	rootDir = t.TempDir()
+	defer branches.reset()
+
+	got := expandArgs([]string{"checkout", "{branch}"}, "missing")
+	if got[0] != "checkout" || got[1] != unknownBranch {
+		t.Errorf("expandArgs = %q, want [checkout %s]", got, unknownBranch)
+	}
+}
+
+func TestSplitWords(t *testing.T) {
+	tests := []struct {
+		value string
+		want  []string
+	}{
+		{"fetch --all", []string{"fetch", "--all"}},
+		{"  log\t-1  ", []string{"log", "-1"}},
+		{"commit -m 'fix bug'", []string{"commit", "-m", "fix bug"}},
+		{`log --format='%h %s'`, []string{"log", "--format=%h %s"}},
+		{`commit -m "say \"hi\" to $USER"`, []string{"commit", "-m", `say "hi" to $USER`}},
+		{`tag a\ b`, []string{"tag", "a b"}},
+		{`echo '' ""`, []string{"echo", "", ""}},
+		{`echo 'a'"b"c`, []string{"echo", "abc"}},
+		{`echo "a\nb"`, []string{"echo", `a\nb`}},
+		{"", []string{}},
+	}
+	for _, test := range tests {
+		got, err := splitWords(test.value)
+		if err != nil {
+			t.Errorf("splitWords(%q): %s", test.value, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("splitWords(%q) = %q, want %q", test.value, got, test.want)
+		}
+	}
+
+	for _, value := range []string{"commit -m 'oops", `log "x`} {
+		if _, err := splitWords(value); err == nil {
+			t.Errorf("splitWords(%q) returned no error", value)
+		}
+	}
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"fmt"
+	"hash/fnv"
+	"os"
+)
+
+// colors is the palette of ANSI foreground colors used for repo prefixes
+var colors = []int{31, 32, 33, 34, 35, 36, 91, 92, 93, 94, 95, 96}
+
+// colorEnabled decides whether prefixes should be colored for the given
+// -color mode. In auto mode a non-empty NO_COLOR disables color, then a
+// CLICOLOR_FORCE other than 0 forces it, and otherwise stdout must be a
+// terminal.
+func colorEnabled(mode string) (bool, error) {
+	switch mode {
+	case "always":
+		return true, nil
+	case "never":
+		return false, nil
+	case "auto":
+		if os.Getenv("NO_COLOR") != "" {
+			return false, nil
+		}
+		if force := os.Getenv("CLICOLOR_FORCE"); force != "" && force != "0" {
+			return true, nil
+		}
+		return isTerminal(os.Stdout), nil
+	default:
+		return false, fmt.Errorf("invalid -color value %q: must be auto, always or never", mode)
+	}
+}
+
+// isTerminal reports whether f is attached to a terminal
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	if err != nil {
+		return false
+	}
+	return info.Mode()&os.ModeCharDevice != 0
+}
+
+// colorize wraps s in the color assigned to name. The same name always gets
+// the same color.
+func colorize(name string, s string) string {
+	hash := fnv.New32a()
+	hash.Write([]byte(name))
+	color := colors[hash.Sum32()%uint32(len(colors))]
+	return fmt.Sprintf("\x1b[%dm%s\x1b[0m", color, s)
+}
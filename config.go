@@ -0,0 +1,224 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+// Config holds the defaults read from a runfile. Zero values mean the
+// built-in default is used.
+type Config struct {
+	MaxConcurrency int      `json:"maxconcurrency" yaml:"maxconcurrency" toml:"maxconcurrency"`
+	Exclude        []string `json:"exclude" yaml:"exclude" toml:"exclude"`
+	Timeout        Duration `json:"timeout" yaml:"timeout" toml:"timeout"`
+
+	Repos map[string]RepoConfig `json:"repos" yaml:"repos" toml:"repos"`
+}
+
+// RepoConfig customizes how commands run in a single repository, keyed by
+// the repository's path relative to the scan root. A repo with Skip set is
+// never run; this is applied in addition to -exclude, so a repo named by
+// either is excluded.
+type RepoConfig struct {
+	Skip bool     `json:"skip" yaml:"skip" toml:"skip"`
+	Env  []string `json:"env" yaml:"env" toml:"env"`
+	// WorkingDir is a subdirectory of the repository to run commands in
+	WorkingDir string `json:"workingdir" yaml:"workingdir" toml:"workingdir"`
+	// Timeout replaces -timeout for this repository's commands
+	Timeout Duration `json:"timeout" yaml:"timeout" toml:"timeout"`
+}
+
+// commandTimeout is how long the repository's commands may run: its own
+// Timeout if set, otherwise global
+func (c RepoConfig) commandTimeout(global time.Duration) time.Duration {
+	if c.Timeout != 0 {
+		return time.Duration(c.Timeout)
+	}
+	return global
+}
+
+// Duration is a time.Duration that is written as a string like "5m" in
+// config files
+type Duration time.Duration
+
+// UnmarshalJSON parses a Go duration string
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return fmt.Errorf("duration must be a string like \"5m\": %s", err.Error())
+	}
+	parsed, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration(parsed)
+	return nil
+}
+
+// UnmarshalText parses a Go duration string, for YAML and TOML
+func (d *Duration) UnmarshalText(b []byte) error {
+	parsed, err := time.ParseDuration(string(b))
+	if err != nil {
+		return err
+	}
+	*d = Duration(parsed)
+	return nil
+}
+
+// MarshalJSON writes the duration as a Go duration string
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+// findRunfile returns the first of runfiles that exists, or the JSON one if
+// none do
+func findRunfile() string {
+	for _, path := range runfiles {
+		if _, err := os.Stat(path); err == nil {
+			return path
+		}
+	}
+	return runfiles[0]
+}
+
+// loadConfig reads the runfile at path, in YAML or TOML if its extension
+// says so and JSON otherwise. A missing file is not an error and results in
+// an empty Config.
+func loadConfig(path string) (Config, error) {
+	cfg := Config{}
+
+	contents, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return cfg, nil
+	}
+	if err != nil {
+		return cfg, err
+	}
+
+	switch filepath.Ext(path) {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(contents, &cfg)
+	case ".toml":
+		err = toml.Unmarshal(contents, &cfg)
+	default:
+		err = json.Unmarshal(contents, &cfg)
+	}
+	if err != nil {
+		return cfg, fmt.Errorf("invalid %s: %s", path, err.Error())
+	}
+	return cfg, nil
+}
+
+// applyConfig copies values from cfg into the flag variables, except for
+// flags that were explicitly set on the command line
+func applyConfig(cfg Config) {
+	set := setFlags()
+	if !set["n"] && cfg.MaxConcurrency > 0 {
+		maxconcurrency = cfg.MaxConcurrency
+	}
+	if !set["exclude"] && len(cfg.Exclude) > 0 {
+		excludeDirectories = strings.Join(cfg.Exclude, ",")
+	}
+	if !set["timeout"] && cfg.Timeout != 0 {
+		timeout = time.Duration(cfg.Timeout)
+	}
+}
+
+// applyEnv copies the PGIT_* environment variables into the flag variables,
+// except for flags that were explicitly set on the command line. It runs
+// after applyConfig, so the environment overrides the runfile.
+func applyEnv() error {
+	set := setFlags()
+
+	if value := os.Getenv("PGIT_EXCLUDE"); !set["exclude"] && value != "" {
+		excludeDirectories = value
+	}
+	if value := os.Getenv("PGIT_MATCH"); !set["match"] && value != "" {
+		matchRepos = value
+	}
+	if value := os.Getenv("PGIT_CONCURRENCY"); !set["n"] && value != "" {
+		if err := workerLimits.Set(value); err != nil {
+			return fmt.Errorf("invalid PGIT_CONCURRENCY %q: %s", value, err.Error())
+		}
+		maxconcurrency = workerLimits[0]
+	}
+	if value := os.Getenv("PGIT_TIMEOUT"); !set["timeout"] && value != "" {
+		parsed, err := time.ParseDuration(value)
+		if err != nil {
+			return fmt.Errorf("invalid PGIT_TIMEOUT %q: %s", value, err.Error())
+		}
+		timeout = parsed
+	}
+	return nil
+}
+
+// effectiveConfig is what -dump-config prints: the settings in effect once
+// flags, the environment and the runfile have been combined
+type effectiveConfig struct {
+	Runfile        string                `json:"runfile"`
+	Root           string                `json:"root"`
+	Depth          int                   `json:"depth"`
+	MaxConcurrency int                   `json:"maxconcurrency"`
+	StepLimits     []int                 `json:"steplimits,omitempty"`
+	Exclude        []string              `json:"exclude"`
+	Match          string                `json:"match"`
+	Timeout        Duration              `json:"timeout"`
+	Deadline       Duration              `json:"deadline"`
+	Repos          map[string]RepoConfig `json:"repos"`
+}
+
+// writeEffectiveConfig writes the effective configuration to w as indented
+// JSON. cfg is the runfile read from path, already applied to the flags.
+func writeEffectiveConfig(w io.Writer, path string, cfg Config) error {
+	effective := effectiveConfig{
+		Runfile:        path,
+		Root:           rootDir,
+		Depth:          maxdepth,
+		MaxConcurrency: maxconcurrency,
+		Exclude:        []string{},
+		Match:          matchRepos,
+		Timeout:        Duration(timeout),
+		Deadline:       Duration(deadline),
+		Repos:          cfg.Repos,
+	}
+	if len(workerLimits) > 1 {
+		effective.StepLimits = workerLimits
+	}
+	for _, dir := range strings.Split(excludeDirectories, ",") {
+		if dir != "" {
+			effective.Exclude = append(effective.Exclude, dir)
+		}
+	}
+	if effective.Repos == nil {
+		effective.Repos = map[string]RepoConfig{}
+	}
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		effective.Runfile = ""
+	}
+
+	out, err := json.MarshalIndent(effective, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "%s\n", out)
+	return err
+}
+
+// setFlags returns the names of the flags set on the command line
+func setFlags() map[string]bool {
+	set := map[string]bool{}
+	flag.Visit(func(f *flag.Flag) {
+		set[f.Name] = true
+	})
+	return set
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/saquib.mian/pgit/runner"
+)
+
+func TestLoadConfigFormats(t *testing.T) {
+	want := Config{
+		MaxConcurrency: 4,
+		Exclude:        []string{"legacy", "vendor/old"},
+		Timeout:        Duration(5 * time.Minute),
+		Repos: map[string]RepoConfig{
+			"api": {
+				Env:        []string{"GOFLAGS=-mod=mod"},
+				WorkingDir: "server",
+				Timeout:    Duration(30 * time.Second),
+			},
+			"docs": {Skip: true},
+		},
+	}
+	for _, name := range []string{"config.json", "config.yaml", "config.toml"} {
+		cfg, err := loadConfig(filepath.Join("testdata", name))
+		if err != nil {
+			t.Errorf("%s: %s", name, err)
+			continue
+		}
+		if !reflect.DeepEqual(cfg, want) {
+			t.Errorf("%s: got %+v, want %+v", name, cfg, want)
+		}
+	}
+}
+
+func TestLoadConfigMissing(t *testing.T) {
+	cfg, err := loadConfig(filepath.Join(t.TempDir(), "pgit.json"))
+	if err != nil || !reflect.DeepEqual(cfg, Config{}) {
+		t.Errorf("missing runfile: got %+v, %v", cfg, err)
+	}
+}
+
+func TestRepoTimeoutOverride(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("needs sh")
+	}
+	defer func(saved bool) { jsonOutput = saved }(jsonOutput)
+	jsonOutput = true
+
+	cfg := Config{Repos: map[string]RepoConfig{
+		"slow": {Timeout: Duration(10 * time.Second)},
+	}}
+	global := 200 * time.Millisecond
+	for repo, wantTimeout := range map[string]bool{"slow": false, "fast": true} {
+		cmd := Command{
+			Repo:       repo,
+			WorkingDir: t.TempDir(),
+			Command:    "sh",
+			Args:       []string{"-c", "exec sleep 0.5"},
+			Timeout:    cfg.Repos[repo].commandTimeout(global),
+		}
+		result := runRepo(context.Background(), []Command{cmd}, wholeSequence)
+		if timedOut := errors.As(result.Error, new(*runner.TimeoutError)); timedOut != wantTimeout {
+			t.Errorf("%s: timed out = %t, want %t (error %v)", repo, timedOut, wantTimeout, result.Error)
+		}
+	}
+}
+
+func TestSettingPrecedence(t *testing.T) {
+	type settings struct {
+		exclude string
+		match   string
+		n       int
+		timeout time.Duration
+	}
+	current := func() settings {
+		return settings{excludeDirectories, matchRepos, maxconcurrency, timeout}
+	}
+	defaults, defaultLimits := current(), workerLimits
+	restore := func() {
+		excludeDirectories, matchRepos, maxconcurrency, timeout = defaults.exclude, defaults.match, defaults.n, defaults.timeout
+		workerLimits = defaultLimits
+	}
+	defer restore()
+
+	file := Config{MaxConcurrency: 3, Exclude: []string{"file"}, Timeout: Duration(3 * time.Minute)}
+	env := map[string]string{"PGIT_EXCLUDE": "env", "PGIT_MATCH": "env-*", "PGIT_CONCURRENCY": "2", "PGIT_TIMEOUT": "2m"}
+	flags := []string{"-exclude", "flag", "-match", "flag-*", "-n", "1", "-timeout", "1m"}
+
+	tests := []struct {
+		name             string
+		file, env, flags bool
+		want             settings
+	}{
+		{"defaults", false, false, false, defaults},
+		// runfiles have no match setting
+		{"file", true, false, false, settings{"file", defaults.match, 3, 3 * time.Minute}},
+		{"env over file", true, true, false, settings{"env", "env-*", 2, 2 * time.Minute}},
+		{"flags over env", true, true, true, settings{"flag", "flag-*", 1, time.Minute}},
+		{"flags over file", true, false, true, settings{"flag", "flag-*", 1, time.Minute}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			restore()
+			for name, value := range env {
+				if !test.env {
+					value = ""
+				}
+				t.Setenv(name, value)
+			}
+			if test.flags {
+				parseTestFlags(t, flags...)
+			} else {
+				parseTestFlags(t)
+			}
+			cfg := Config{}
+			if test.file {
+				cfg = file
+			}
+
+			// as main does it
+			maxconcurrency = workerLimits[0]
+			applyConfig(cfg)
+			if err := applyEnv(); err != nil {
+				t.Fatal(err)
+			}
+
+			if got := current(); got != test.want {
+				t.Errorf("got %+v, want %+v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestApplyEnvInvalid(t *testing.T) {
+	defer func(n int, limits concurrencyList, d time.Duration) {
+		maxconcurrency, workerLimits, timeout = n, limits, d
+	}(maxconcurrency, workerLimits, timeout)
+	parseTestFlags(t)
+
+	for name, value := range map[string]string{"PGIT_CONCURRENCY": "many", "PGIT_TIMEOUT": "soon"} {
+		t.Run(name, func(t *testing.T) {
+			t.Setenv(name, value)
+			if err := applyEnv(); err == nil {
+				t.Errorf("%s=%s was accepted", name, value)
+			}
+		})
+	}
+}
@@ -0,0 +1,24 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// confirmRun lists the repos and command lines about to run and asks on
+// stdin whether to go ahead. Anything but "y" declines.
+func confirmRun(w io.Writer, repos []string, steps []Command) bool {
+	for _, repo := range repos {
+		fmt.Fprintf(w, "  %s\n", repo)
+	}
+	for _, step := range steps {
+		fmt.Fprintf(w, "will run: %s\n", strings.TrimSpace(step.Command+" "+strings.Join(step.Args, " ")))
+	}
+	fmt.Fprintf(w, "Run against %d repos? [y/N] ", len(repos))
+
+	answer, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+	return strings.ToLower(strings.TrimSpace(answer)) == "y"
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// diagLogger writes pgit's own diagnostics, as opposed to the output of the
+// commands it runs
+type diagLogger interface {
+	// message writes msg at level info, warning or error. Text output goes
+	// to w, with warnings and errors prefixed by their level.
+	message(w io.Writer, level string, repo string, msg string)
+	// event records something that text output shows in its own way, such
+	// as a repository finishing; text loggers ignore it
+	event(name string, repo string, fields map[string]interface{})
+}
+
+// diag is the logger chosen by -log-format
+var diag diagLogger = textDiag{}
+
+// textDiag writes diagnostics as plain lines
+type textDiag struct{}
+
+func (textDiag) message(w io.Writer, level string, repo string, msg string) {
+	if level != "info" {
+		msg = level + ": " + msg
+	}
+	fmt.Fprintln(w, msg)
+}
+
+func (textDiag) event(name string, repo string, fields map[string]interface{}) {}
+
+// jsonDiag writes each diagnostic as a JSON object on its own line
+type jsonDiag struct {
+	mu  sync.Mutex
+	out io.Writer
+}
+
+func (d *jsonDiag) message(w io.Writer, level string, repo string, msg string) {
+	d.write(map[string]interface{}{"level": level, "event": "message", "repo": repo, "message": msg})
+}
+
+func (d *jsonDiag) event(name string, repo string, fields map[string]interface{}) {
+	record := map[string]interface{}{"level": "info", "event": name, "repo": repo}
+	for k, v := range fields {
+		record[k] = v
+	}
+	d.write(record)
+}
+
+func (d *jsonDiag) write(record map[string]interface{}) {
+	d.writeTo(d.out, record)
+}
+
+// writeTo writes record to w, holding the lock that keeps records whole
+func (d *jsonDiag) writeTo(w io.Writer, record map[string]interface{}) {
+	record["time"] = time.Now().Format(time.RFC3339Nano)
+	if record["repo"] == "" {
+		delete(record, "repo")
+	}
+	line, err := json.Marshal(record)
+	if err != nil {
+		return
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	fmt.Fprintf(w, "%s\n", line)
+}
+
+// outputEvents writes each line it is given, as a logger writes them, to out
+// as an output event
+type outputEvents struct {
+	diag   *jsonDiag
+	out    io.Writer
+	repo   string
+	stream string
+}
+
+func (w outputEvents) Write(p []byte) (int, error) {
+	w.diag.writeTo(w.out, map[string]interface{}{
+		"level":  "info",
+		"event":  "output",
+		"repo":   w.repo,
+		"stream": w.stream,
+		"line":   strings.TrimSuffix(string(p), "\n"),
+	})
+	return len(p), nil
+}
+
+// stderrLogger returns the logger for a command's stderr on its way to w:
+// lines with prefix, or output events for repo with -log-format json so
+// that stderr holds nothing but JSON
+func stderrLogger(w io.Writer, repo string, prefix string) *log.Logger {
+	if d, ok := diag.(*jsonDiag); ok {
+		return log.New(outputEvents{diag: d, out: w, repo: repo, stream: "stderr"}, "", 0)
+	}
+	return log.New(w, prefix, 0)
+}
+
+// newDiagLogger returns the logger for a -log-format value
+func newDiagLogger(format string) (diagLogger, error) {
+	switch format {
+	case "text":
+		return textDiag{}, nil
+	case "json":
+		return &jsonDiag{out: os.Stderr}, nil
+	default:
+		return nil, fmt.Errorf("invalid -log-format value %q: must be text or json", format)
+	}
+}
+
+// jsonLogs reports whether diagnostics are written as JSON, in which case
+// the text-only headers and failure listing are left out
+func jsonLogs() bool {
+	_, ok := diag.(*jsonDiag)
+	return ok
+}
+
+// warnf writes a warning to stderr
+func warnf(format string, args ...interface{}) {
+	diag.message(os.Stderr, "warning", "", fmt.Sprintf(format, args...))
+}
+
+// resultFields describes a finished result for a repo-done event
+func resultFields(result CommandResult) map[string]interface{} {
+	fields := map[string]interface{}{
+		"success":   result.Success,
+		"step":      result.Step + 1,
+		"exit_code": result.ExitCode,
+		"duration":  result.Duration.Seconds(),
+	}
+	if result.Skipped != "" {
+		fields["skipped"] = result.Skipped
+	}
+	if result.Error != nil {
+		fields["error"] = result.Error.Error()
+	}
+	if !result.Success {
+		fields["level"] = "error"
+	}
+	return fields
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/saquib.mian/pgit/logwriter"
+)
+
+func TestStderrLoggerJSON(t *testing.T) {
+	defer func(saved diagLogger) { diag = saved }(diag)
+	diag = &jsonDiag{}
+
+	var out bytes.Buffer
+	w := logwriter.NewLogWriter(stderrLogger(&out, "repo", "[repo] "))
+	w.Write([]byte("one\ntw"))
+	w.Write([]byte("o\n"))
+	w.Flush()
+
+	lines := strings.Split(strings.TrimSuffix(out.String(), "\n"), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("got %q, want two records", out.String())
+	}
+	for i, want := range []string{"one", "two"} {
+		var record map[string]interface{}
+		if err := json.Unmarshal([]byte(lines[i]), &record); err != nil {
+			t.Fatalf("line %q is not JSON: %s", lines[i], err)
+		}
+		if record["event"] != "output" || record["stream"] != "stderr" || record["repo"] != "repo" || record["line"] != want {
+			t.Errorf("record %d = %v, want an output event for %q", i, record, want)
+		}
+	}
+}
+
+func TestStderrLoggerText(t *testing.T) {
+	defer func(saved diagLogger) { diag = saved }(diag)
+	diag = textDiag{}
+
+	var out bytes.Buffer
+	w := logwriter.NewLogWriter(stderrLogger(&out, "repo", "[repo] "))
+	w.Write([]byte("one\n"))
+	if want := "[repo] one\n"; out.String() != want {
+		t.Errorf("got %q, want %q", out.String(), want)
+	}
+}
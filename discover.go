@@ -0,0 +1,150 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/saquib.mian/pgit/runner"
+)
+
+// discoverRepos finds the repositories below root with runner.Discover.
+// When requireGit is false every directory counts as a repository. Bare
+// repositories are left out unless -include-bare is set.
+func discoverRepos(root string, depth int, requireGit bool) ([]string, error) {
+	return runner.Discover(root, runner.DiscoverOptions{
+		Depth:          depth,
+		AllDirectories: !requireGit,
+		IncludeBare:    includeBare,
+		Git:            gitBinary,
+	})
+}
+
+// withSubmodules returns repos with the initialized submodules of each one
+// listed after it, as paths relative to root
+func withSubmodules(root string, repos []string) []string {
+	expanded := []string{}
+	for _, repo := range repos {
+		expanded = append(expanded, repo)
+
+		paths, err := submodulePaths(repoPath(root, repo))
+		if err != nil {
+			warnf("could not read submodules of %s: %s", repo, err.Error())
+			continue
+		}
+		for _, path := range paths {
+			submodule := filepath.Join(repo, path)
+			if isRepo(repoPath(root, submodule)) {
+				expanded = append(expanded, submodule)
+			}
+		}
+	}
+	return expanded
+}
+
+// isRepo reports whether dir is the root of a git repository
+func isRepo(dir string) bool {
+	return runner.IsRepo(gitBinary, dir)
+}
+
+// isBareRepo reports whether dir is a bare repository
+func isBareRepo(dir string) bool {
+	return runner.IsBareRepo(gitBinary, dir)
+}
+
+// readRepoList reads one directory per line from r, or NUL-separated ones
+// with -0. Blank lines and lines starting with # are ignored.
+func readRepoList(r io.Reader) ([]string, error) {
+	repos := []string{}
+	scanner := bufio.NewScanner(r)
+	if nullSeparated {
+		scanner.Split(scanNull)
+	}
+	for scanner.Scan() {
+		line := scanner.Text()
+		if !nullSeparated {
+			line = strings.TrimSpace(line)
+		}
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		repos = append(repos, filepath.Clean(line))
+	}
+	return repos, scanner.Err()
+}
+
+// scanNull is a bufio.SplitFunc for NUL-separated entries, as written by
+// find -print0
+func scanNull(data []byte, atEOF bool) (advance int, token []byte, err error) {
+	if i := bytes.IndexByte(data, 0); i >= 0 {
+		return i + 1, data[:i], nil
+	}
+	if atEOF && len(data) > 0 {
+		return len(data), data, nil
+	}
+	return 0, nil, nil
+}
+
+// repoPath returns where repo is: repo itself when it is absolute, as
+// -from entries may be, and otherwise repo inside root
+func repoPath(root string, repo string) string {
+	if filepath.IsAbs(repo) {
+		return repo
+	}
+	return filepath.Join(root, repo)
+}
+
+// loadRepoList reads the repository list named by -from, where "-" means
+// stdin. Relative entries are inside root and absolute ones are kept as
+// they are; those that are not git repositories are dropped with a warning
+// unless requireGit is false.
+func loadRepoList(from string, root string, requireGit bool) ([]string, error) {
+	var r io.Reader = os.Stdin
+	if from != "-" {
+		f, err := os.Open(from)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		r = f
+	}
+
+	listed, err := readRepoList(r)
+	if err != nil {
+		return nil, err
+	}
+
+	repos := []string{}
+	for _, repo := range listed {
+		dir := repoPath(root, repo)
+		if isBareRepo(dir) && !includeBare {
+			warnf("skipping %s: bare repository", repo)
+			continue
+		}
+		if requireGit && !isRepo(dir) && !isBareRepo(dir) {
+			warnf("skipping %s: not a git repository", repo)
+			continue
+		}
+		repos = append(repos, repo)
+	}
+	return repos, nil
+}
+
+// publishOrder returns repos in the order they are handed to workers: sorted
+// by path, or shuffled reproducibly when seed is non-zero
+func publishOrder(repos []string, seed int64) []string {
+	ordered := append([]string{}, repos...)
+	sort.Strings(ordered)
+	if seed != 0 {
+		r := rand.New(rand.NewSource(seed))
+		r.Shuffle(len(ordered), func(i, j int) {
+			ordered[i], ordered[j] = ordered[j], ordered[i]
+		})
+	}
+	return ordered
+}
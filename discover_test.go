@@ -0,0 +1,56 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestPublishOrderSorted(t *testing.T) {
+	repos := []string{"web", "api", "team/c", "b", "team/a"}
+	got := publishOrder(repos, 0)
+	want := []string{"api", "b", "team/a", "team/c", "web"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("publishOrder = %q, want %q", got, want)
+	}
+	if repos[0] != "web" {
+		t.Error("publishOrder reordered its argument")
+	}
+}
+
+func TestPublishOrderSeeded(t *testing.T) {
+	repos := []string{"e", "d", "c", "b", "a"}
+	first := publishOrder(repos, 42)
+	// the discovered order does not matter for a given seed
+	second := publishOrder([]string{"a", "b", "c", "d", "e"}, 42)
+	if !reflect.DeepEqual(first, second) {
+		t.Errorf("seed 42 gave %q then %q", first, second)
+	}
+}
+
+func TestLoadRepoListPaths(t *testing.T) {
+	root := t.TempDir()
+	elsewhere := t.TempDir()
+	newTestRepo(t, filepath.Join(root, "relative"))
+	newTestRepo(t, filepath.Join(elsewhere, "absolute"))
+	os.Mkdir(filepath.Join(root, "plain"), 0755)
+
+	list := filepath.Join(t.TempDir(), "repos.txt")
+	contents := "relative\n" + filepath.Join(elsewhere, "absolute") + "\nplain\n"
+	if err := os.WriteFile(list, []byte(contents), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	repos, err := loadRepoList(list, root, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"relative", filepath.Join(elsewhere, "absolute")}
+	if !reflect.DeepEqual(repos, want) {
+		t.Errorf("loadRepoList = %q, want %q", repos, want)
+	}
+	if dir := repoPath(root, repos[1]); dir != want[1] {
+		t.Errorf("absolute entry resolved to %s", dir)
+	}
+}
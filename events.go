@@ -0,0 +1,67 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+)
+
+// event is one line written to -events-fd
+type event struct {
+	Type       string  `json:"type"`
+	Repo       string  `json:"repo"`
+	Success    *bool   `json:"success,omitempty"`
+	Skipped    string  `json:"skipped,omitempty"`
+	ExitCode   *int    `json:"exit_code,omitempty"`
+	DurationMs float64 `json:"duration_ms,omitempty"`
+}
+
+// eventWriter writes newline-delimited JSON events to a file descriptor for
+// programs that follow the run
+type eventWriter struct {
+	mu  sync.Mutex
+	enc *json.Encoder
+}
+
+// openEvents wraps the already open file descriptor fd, failing if it
+// cannot be written to
+func openEvents(fd int) (*eventWriter, error) {
+	f := os.NewFile(uintptr(fd), "events")
+	if f == nil {
+		return nil, fmt.Errorf("-events-fd %d is not a valid file descriptor", fd)
+	}
+	if _, err := f.Stat(); err != nil {
+		return nil, fmt.Errorf("-events-fd %d is not open", fd)
+	}
+	if _, err := f.Write(nil); err != nil {
+		return nil, fmt.Errorf("-events-fd %d is not writable", fd)
+	}
+	return &eventWriter{enc: json.NewEncoder(f)}, nil
+}
+
+func (e *eventWriter) write(ev event) {
+	if e == nil {
+		return
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.enc.Encode(ev)
+}
+
+// started records that a repository's commands have begun
+func (e *eventWriter) started(cmd Command) {
+	e.write(event{Type: "start", Repo: cmd.Repo})
+}
+
+// finished records a repository's result
+func (e *eventWriter) finished(result CommandResult) {
+	e.write(event{
+		Type:       "done",
+		Repo:       result.Command.Repo,
+		Success:    &result.Success,
+		Skipped:    result.Skipped,
+		ExitCode:   &result.ExitCode,
+		DurationMs: result.Duration.Seconds() * 1000,
+	})
+}
@@ -0,0 +1,246 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// regexPrefix marks a pattern as a regular expression rather than a glob
+const regexPrefix = "re:"
+
+// matchPattern reports whether name matches pattern. Patterns are globs
+// unless prefixed with "re:", in which case the rest is a regular
+// expression.
+func matchPattern(pattern string, name string) (bool, error) {
+	if strings.HasPrefix(pattern, regexPrefix) {
+		re, err := regexp.Compile(strings.TrimPrefix(pattern, regexPrefix))
+		if err != nil {
+			return false, err
+		}
+		return re.MatchString(name), nil
+	}
+	return filepath.Match(pattern, name)
+}
+
+// shouldInclude reports whether repo, a path relative to the scan root,
+// should be run. The match pattern is tested against the repository's name;
+// an empty match includes everything. An exclusion always wins over a match.
+func shouldInclude(repo string, match string, exclude []string) bool {
+	for _, excluded := range exclude {
+		if isExcluded(repo, excluded) {
+			return false
+		}
+	}
+
+	if match == "" {
+		return true
+	}
+	matched, err := matchPattern(match, filepath.Base(repo))
+	return err == nil && matched
+}
+
+// isExcluded reports whether an -exclude entry names repo. An entry
+// containing a slash must equal the repository's path relative to the root;
+// a bare name matches a repository with that name at any depth. Either kind
+// of match excludes the repository.
+func isExcluded(repo string, excluded string) bool {
+	excluded = strings.Trim(filepath.ToSlash(excluded), "/")
+	if excluded == "" {
+		return false
+	}
+
+	name := filepath.ToSlash(repo)
+	if !strings.Contains(excluded, "/") {
+		name = filepath.Base(repo)
+	}
+
+	if ignoreCase {
+		return strings.EqualFold(name, excluded)
+	}
+	return name == excluded
+}
+
+// loadIgnoreFile reads gitignore-style patterns from path, skipping blank
+// lines and comments. A missing file has no patterns.
+func loadIgnoreFile(path string) ([]string, error) {
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	patterns := []string{}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		patterns = append(patterns, line)
+	}
+	return patterns, scanner.Err()
+}
+
+// isIgnored reports whether repo, a path relative to the scan root, is
+// excluded by patterns. As with gitignore the last matching pattern wins and
+// a leading ! re-includes a repo. Patterns containing a slash match the whole
+// path; others match the repo's name.
+func isIgnored(repo string, patterns []string) bool {
+	ignored := false
+	for _, pattern := range patterns {
+		negated := strings.HasPrefix(pattern, "!")
+		pattern = strings.TrimSuffix(strings.TrimPrefix(pattern, "!"), "/")
+
+		name := filepath.Base(repo)
+		if strings.Contains(pattern, "/") {
+			name = filepath.ToSlash(repo)
+			pattern = strings.TrimPrefix(pattern, "/")
+		}
+
+		if matched, err := matchPattern(pattern, name); err == nil && matched {
+			ignored = !negated
+		}
+	}
+	return ignored
+}
+
+// onBranches reports whether branch is one of the comma-separated names
+func onBranches(branch string, names string) bool {
+	for _, name := range strings.Split(names, ",") {
+		if strings.TrimSpace(name) == branch {
+			return true
+		}
+	}
+	return false
+}
+
+// remoteExcluded reports whether the origin URL of the repository at dir
+// matches pattern. In globs * and ? match slashes too, since URLs are not
+// paths. Repositories without an origin never match.
+func remoteExcluded(dir string, pattern string) bool {
+	remote, err := repoRemote(dir)
+	if err != nil || remote == "" {
+		return false
+	}
+	if strings.HasPrefix(pattern, regexPrefix) {
+		matched, _ := matchPattern(pattern, remote)
+		return matched
+	}
+
+	re, err := regexp.Compile(globRegexp(pattern))
+	if err != nil {
+		return false
+	}
+	return re.MatchString(remote)
+}
+
+// globRegexp converts a glob, already checked with filepath.Match, to an
+// anchored regular expression. Character classes and \ escapes mean what
+// they do to filepath.Match.
+func globRegexp(pattern string) string {
+	re := &strings.Builder{}
+	re.WriteString("^")
+	inClass := false
+	for i := 0; i < len(pattern); i++ {
+		c := pattern[i]
+		switch {
+		case c == '\\' && i+1 < len(pattern):
+			i++
+			re.WriteString(regexp.QuoteMeta(pattern[i : i+1]))
+		case inClass && c == ']':
+			re.WriteByte(']')
+			inClass = false
+		case inClass && c == '-':
+			re.WriteByte('-')
+		case inClass:
+			re.WriteString(regexp.QuoteMeta(pattern[i : i+1]))
+		case c == '[':
+			re.WriteByte('[')
+			inClass = true
+			if i+1 < len(pattern) && pattern[i+1] == '^' {
+				re.WriteByte('^')
+				i++
+			}
+		case c == '*':
+			re.WriteString(".*")
+		case c == '?':
+			re.WriteString(".")
+		default:
+			re.WriteString(regexp.QuoteMeta(pattern[i : i+1]))
+		}
+	}
+	re.WriteString("$")
+	return re.String()
+}
+
+// parseSince parses a -changed-since cutoff: a date as 2006-01-02, taken as
+// midnight local time, an RFC 3339 timestamp, or a duration before now
+func parseSince(value string, now time.Time) (time.Time, error) {
+	if d, err := time.ParseDuration(value); err == nil {
+		return now.Add(-d), nil
+	}
+	if t, err := time.ParseInLocation("2006-01-02", value, time.Local); err == nil {
+		return t, nil
+	}
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	return time.Time{}, fmt.Errorf("must be a date like 2006-01-02, a timestamp or a duration like 72h")
+}
+
+// changedSince reports whether a commit at committed is after cutoff. Both
+// carry their own time zones, so timestamps in different zones compare by
+// the instant they name.
+func changedSince(committed time.Time, cutoff time.Time) bool {
+	return committed.After(cutoff)
+}
+
+// activityTime is when the repository at dir was last active, going by the
+// modification time of its HEAD reflog, which git appends to on commits,
+// checkouts and resets. Without a reflog the file of the branch HEAD points
+// at is used, then HEAD itself, and directories that are not repositories
+// use their own modification time.
+func activityTime(dir string) (time.Time, error) {
+	if gitdir, err := gitDir(dir); err == nil {
+		candidates := []string{filepath.Join(gitdir, "logs", "HEAD")}
+		if contents, err := ioutil.ReadFile(filepath.Join(gitdir, "HEAD")); err == nil {
+			if head := strings.TrimSpace(string(contents)); strings.HasPrefix(head, "ref: ") {
+				ref := strings.TrimPrefix(head, "ref: ")
+				candidates = append(candidates, filepath.Join(gitdir, filepath.FromSlash(ref)))
+			}
+		}
+		candidates = append(candidates, filepath.Join(gitdir, "HEAD"))
+		for _, path := range candidates {
+			if info, err := os.Stat(path); err == nil {
+				return info.ModTime(), nil
+			}
+		}
+	}
+	info, err := os.Stat(dir)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return info.ModTime(), nil
+}
+
+// withinAge reports whether a repository last active at active passes
+// -newer-than and -older-than, where zero means no limit
+func withinAge(active time.Time, now time.Time, newer time.Duration, older time.Duration) bool {
+	age := now.Sub(active)
+	if newer > 0 && age > newer {
+		return false
+	}
+	if older > 0 && age < older {
+		return false
+	}
+	return true
+}
@@ -0,0 +1,206 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestShouldInclude(t *testing.T) {
+	tests := []struct {
+		repo    string
+		match   string
+		exclude []string
+		want    bool
+	}{
+		{"api", "", nil, true},
+		{"api", "a*", nil, true},
+		{"web", "a*", nil, false},
+		{"team/api-server", "api-*", nil, true},
+		{"api", "re:^(api|web)$", nil, true},
+		{"apis", "re:^(api|web)$", nil, false},
+		{"api", "re:(", nil, false},
+		{"api", "a*", []string{"api"}, false},
+		{"api", "re:^api$", []string{"api"}, false},
+		{"api", "", []string{"web"}, true},
+	}
+	for _, test := range tests {
+		if got := shouldInclude(test.repo, test.match, test.exclude); got != test.want {
+			t.Errorf("shouldInclude(%q, %q, %q) = %t, want %t", test.repo, test.match, test.exclude, got, test.want)
+		}
+	}
+}
+
+func TestIsExcluded(t *testing.T) {
+	defer func(saved bool) { ignoreCase = saved }(ignoreCase)
+	ignoreCase = false
+
+	tests := []struct {
+		repo     string
+		excluded string
+		want     bool
+	}{
+		// a bare name matches at any depth
+		{"foo", "foo", true},
+		{"bar/foo", "foo", true},
+		// a path matches only that path
+		{"bar/foo", "bar/foo", true},
+		{"foo", "bar/foo", false},
+		{"baz/bar/foo", "bar/foo", false},
+		{"bar/foo", "/bar/foo/", true},
+		{"Foo", "foo", false},
+	}
+	for _, test := range tests {
+		if got := isExcluded(test.repo, test.excluded); got != test.want {
+			t.Errorf("isExcluded(%q, %q) = %t, want %t", test.repo, test.excluded, got, test.want)
+		}
+	}
+}
+
+func TestExcludeNameAndPath(t *testing.T) {
+	// either kind of entry excludes, whatever the other says
+	exclude := []string{"foo", "bar/baz"}
+	for repo, want := range map[string]bool{
+		"foo":     false,
+		"bar/foo": false,
+		"bar/baz": false,
+		"baz":     true,
+		"qux/baz": true,
+	} {
+		if got := shouldInclude(repo, "", exclude); got != want {
+			t.Errorf("shouldInclude(%q, %q) = %t, want %t", repo, exclude, got, want)
+		}
+	}
+}
+
+func TestIsExcludedIgnoreCase(t *testing.T) {
+	defer func(saved bool) { ignoreCase = saved }(ignoreCase)
+	ignoreCase = true
+
+	if !isExcluded("Bar/Foo", "bar/foo") || !isExcluded("Foo", "foo") {
+		t.Error("-ignore-case did not match regardless of case")
+	}
+}
+
+func TestParseSince(t *testing.T) {
+	now := time.Date(2024, 3, 10, 15, 30, 0, 0, time.UTC)
+	tests := []struct {
+		value string
+		want  time.Time
+	}{
+		{"72h", now.Add(-72 * time.Hour)},
+		{"90m", now.Add(-90 * time.Minute)},
+		{"2024-03-01", time.Date(2024, 3, 1, 0, 0, 0, 0, time.Local)},
+		{"2024-03-01T12:00:00+02:00", time.Date(2024, 3, 1, 10, 0, 0, 0, time.UTC)},
+	}
+	for _, test := range tests {
+		got, err := parseSince(test.value, now)
+		if err != nil {
+			t.Errorf("parseSince(%q): %s", test.value, err)
+			continue
+		}
+		if !got.Equal(test.want) {
+			t.Errorf("parseSince(%q) = %s, want %s", test.value, got, test.want)
+		}
+	}
+
+	for _, value := range []string{"", "yesterday", "2024-13-01", "3 days"} {
+		if _, err := parseSince(value, now); err == nil {
+			t.Errorf("parseSince(%q) returned no error", value)
+		}
+	}
+}
+
+func TestChangedSince(t *testing.T) {
+	cutoff := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	east := time.FixedZone("UTC+2", 2*60*60)
+	tests := []struct {
+		committed time.Time
+		want      bool
+	}{
+		{cutoff.Add(time.Second), true},
+		{cutoff.Add(-time.Second), false},
+		// exactly at the cutoff is not after it
+		{cutoff, false},
+		// 13:00 at UTC+2 is 11:00 UTC
+		{time.Date(2024, 3, 1, 13, 0, 0, 0, east), false},
+		{time.Date(2024, 3, 1, 15, 0, 0, 0, east), true},
+	}
+	for _, test := range tests {
+		if got := changedSince(test.committed, cutoff); got != test.want {
+			t.Errorf("changedSince(%s, %s) = %t, want %t", test.committed, cutoff, got, test.want)
+		}
+	}
+}
+
+func TestWithinAge(t *testing.T) {
+	now := time.Date(2024, 3, 10, 12, 0, 0, 0, time.UTC)
+	day := 24 * time.Hour
+	tests := []struct {
+		age          time.Duration
+		newer, older time.Duration
+		want         bool
+	}{
+		{day, 0, 0, true},
+		{day, 2 * day, 0, true},
+		{3 * day, 2 * day, 0, false},
+		{3 * day, 0, 2 * day, true},
+		{day, 0, 2 * day, false},
+		{3 * day, 7 * day, 2 * day, true},
+		{8 * day, 7 * day, 2 * day, false},
+	}
+	for _, test := range tests {
+		if got := withinAge(now.Add(-test.age), now, test.newer, test.older); got != test.want {
+			t.Errorf("withinAge(%s old, newer %s, older %s) = %t, want %t", test.age, test.newer, test.older, got, test.want)
+		}
+	}
+}
+
+func TestActivityTime(t *testing.T) {
+	root := t.TempDir()
+	now := time.Now().Truncate(time.Second)
+	day := 24 * time.Hour
+
+	// touch creates path, with its parent directories, modified age ago
+	touch := func(path string, contents string, age time.Duration) {
+		t.Helper()
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(contents), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(path, now.Add(-age), now.Add(-age)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// a commit appends to the reflog and leaves HEAD alone
+	touch(filepath.Join(root, "reflog", ".git", "HEAD"), "ref: refs/heads/main\n", 100*day)
+	touch(filepath.Join(root, "reflog", ".git", "logs", "HEAD"), "", day)
+	// without a reflog, the branch HEAD points at
+	touch(filepath.Join(root, "ref", ".git", "HEAD"), "ref: refs/heads/main\n", 100*day)
+	touch(filepath.Join(root, "ref", ".git", "refs", "heads", "main"), "", 2*day)
+	// a detached HEAD with no reflog
+	touch(filepath.Join(root, "detached", ".git", "HEAD"), "0123456789abcdef0123456789abcdef01234567\n", 3*day)
+	if err := os.Mkdir(filepath.Join(root, "plain"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	os.Chtimes(filepath.Join(root, "plain"), now.Add(-4*day), now.Add(-4*day))
+
+	for repo, age := range map[string]time.Duration{"reflog": day, "ref": 2 * day, "detached": 3 * day, "plain": 4 * day} {
+		active, err := activityTime(filepath.Join(root, repo))
+		if err != nil {
+			t.Errorf("%s: %s", repo, err)
+			continue
+		}
+		if want := now.Add(-age); !active.Equal(want) {
+			t.Errorf("%s: active at %s, want %s", repo, active, want)
+		}
+	}
+
+	active, _ := activityTime(filepath.Join(root, "reflog"))
+	if !withinAge(active, now, 2*day, 0) || withinAge(active, now, 0, 2*day) {
+		t.Error("a repository committed to a day ago should pass -newer-than 48h and fail -older-than 48h")
+	}
+}
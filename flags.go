@@ -0,0 +1,74 @@
+package main
+
+import (
+	"fmt"
+	"runtime"
+	"strconv"
+	"strings"
+)
+
+// stringList is a flag.Value that collects every occurrence of a repeated
+// flag
+type stringList []string
+
+func (s *stringList) String() string {
+	return strings.Join(*s, ",")
+}
+
+// Set appends value to the list
+func (s *stringList) Set(value string) error {
+	*s = append(*s, value)
+	return nil
+}
+
+// concurrency is a flag.Value for a worker count that also accepts "auto"
+// for one worker per CPU. Zero means one worker per repository.
+type concurrency int
+
+func (c *concurrency) String() string {
+	return strconv.Itoa(int(*c))
+}
+
+// Set parses a non-negative count or "auto"
+func (c *concurrency) Set(value string) error {
+	if value == "auto" {
+		*c = concurrency(runtime.NumCPU())
+		return nil
+	}
+
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		return fmt.Errorf("must be a number or auto")
+	}
+	if n < 0 {
+		return fmt.Errorf("must not be negative")
+	}
+	*c = concurrency(n)
+	return nil
+}
+
+// concurrencyList is a flag.Value for -n: one worker count, or a
+// comma-separated count for each step of a sequence
+type concurrencyList []int
+
+func (l *concurrencyList) String() string {
+	counts := []string{}
+	for _, n := range *l {
+		counts = append(counts, strconv.Itoa(n))
+	}
+	return strings.Join(counts, ",")
+}
+
+// Set parses one or more comma-separated counts, each as concurrency does
+func (l *concurrencyList) Set(value string) error {
+	counts := concurrencyList{}
+	for _, part := range strings.Split(value, ",") {
+		var c concurrency
+		if err := c.Set(strings.TrimSpace(part)); err != nil {
+			return err
+		}
+		counts = append(counts, int(c))
+	}
+	*l = counts
+	return nil
+}
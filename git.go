@@ -0,0 +1,297 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/saquib.mian/pgit/runner"
+)
+
+// gitOutput runs git with args in dir and returns its trimmed stdout
+func gitOutput(dir string, args ...string) (string, error) {
+	return runner.GitOutput(gitBinary, dir, args...)
+}
+
+// isDirty reports whether the repository in repoDir has uncommitted changes
+func isDirty(repoDir string) (bool, error) {
+	status, err := gitOutput(repoDir, "status", "--porcelain")
+	if err != nil {
+		return false, err
+	}
+	return status != "", nil
+}
+
+// lookupCache memoizes the result of a git lookup per repository so that
+// features sharing it only spawn git once
+type lookupCache struct {
+	mu      sync.Mutex
+	results map[string]*lookupResult
+}
+
+type lookupResult struct {
+	once  sync.Once
+	value string
+	err   error
+}
+
+// get returns the cached result for dir, calling lookup the first time.
+// Lookups for different directories run concurrently; callers asking for
+// the same directory wait for the one lookup already under way.
+func (c *lookupCache) get(dir string, lookup func(string) (string, error)) (string, error) {
+	c.mu.Lock()
+	if c.results == nil {
+		c.results = map[string]*lookupResult{}
+	}
+	result, ok := c.results[dir]
+	if !ok {
+		result = &lookupResult{}
+		c.results[dir] = result
+	}
+	c.mu.Unlock()
+
+	result.once.Do(func() {
+		result.value, result.err = lookup(dir)
+	})
+	return result.value, result.err
+}
+
+// reset forgets every cached result
+func (c *lookupCache) reset() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.results = nil
+}
+
+var branches lookupCache
+
+// detachedBranch is shown in place of a branch name for a detached HEAD
+const detachedBranch = "(detached)"
+
+// unknownBranch is shown when a directory's branch cannot be determined
+const unknownBranch = "-"
+
+// repoBranch returns the name of the branch checked out in dir, or
+// detachedBranch when HEAD is detached. Lookups are cached.
+func repoBranch(dir string) (string, error) {
+	return branches.get(dir, func(dir string) (string, error) {
+		branch, err := gitOutput(dir, "rev-parse", "--abbrev-ref", "HEAD")
+		if err != nil {
+			return "", err
+		}
+		if branch == "HEAD" {
+			return detachedBranch, nil
+		}
+		return branch, nil
+	})
+}
+
+// displayBranch is like repoBranch but degrades to a placeholder on error
+func displayBranch(dir string) string {
+	branch, err := repoBranch(dir)
+	if err != nil {
+		return unknownBranch
+	}
+	return branch
+}
+
+// submodulePaths returns the paths, relative to dir, of the submodules
+// declared in dir's .gitmodules file
+func submodulePaths(dir string) ([]string, error) {
+	if _, err := os.Stat(filepath.Join(dir, ".gitmodules")); os.IsNotExist(err) {
+		return nil, nil
+	}
+
+	out, err := gitOutput(dir, "config", "--file", ".gitmodules", "--get-regexp", `^submodule\..*\.path$`)
+	if err != nil {
+		return nil, err
+	}
+
+	paths := []string{}
+	for _, line := range strings.Split(out, "\n") {
+		fields := strings.SplitN(line, " ", 2)
+		if len(fields) == 2 {
+			paths = append(paths, filepath.FromSlash(fields[1]))
+		}
+	}
+	return paths, nil
+}
+
+var remotes lookupCache
+
+// repoRemote returns the URL of dir's origin remote. Lookups are cached.
+func repoRemote(dir string) (string, error) {
+	return remotes.get(dir, func(dir string) (string, error) {
+		return gitOutput(dir, "remote", "get-url", "origin")
+	})
+}
+
+// remoteHost extracts the host name from a git remote URL, handling both
+// URL syntax and scp-like user@host:path syntax. Local paths have no host.
+func remoteHost(remote string) string {
+	if strings.Contains(remote, "://") {
+		u, err := url.Parse(remote)
+		if err != nil {
+			return ""
+		}
+		return u.Hostname()
+	}
+
+	colon := strings.Index(remote, ":")
+	if colon < 0 || strings.ContainsAny(remote[:colon], `/\`) {
+		return ""
+	}
+	host := remote[:colon]
+	if at := strings.LastIndex(host, "@"); at >= 0 {
+		host = host[at+1:]
+	}
+	return host
+}
+
+// errNoCommits is returned for repositories whose HEAD has no commits yet
+var errNoCommits = errors.New("no commits")
+
+// lastCommitTime returns the committer date of HEAD in dir
+func lastCommitTime(dir string) (time.Time, error) {
+	if _, err := gitOutput(dir, "rev-parse", "--verify", "--quiet", "HEAD"); err != nil {
+		return time.Time{}, errNoCommits
+	}
+	date, err := gitOutput(dir, "log", "-1", "--format=%cI")
+	if err != nil {
+		return time.Time{}, err
+	}
+	return time.Parse(time.RFC3339, date)
+}
+
+// errNoUpstream is returned for branches that do not track an upstream
+var errNoUpstream = errors.New("no upstream")
+
+// aheadBehind returns how many commits HEAD in dir is ahead of and behind
+// its upstream branch
+func aheadBehind(dir string) (ahead int, behind int, err error) {
+	if _, err := gitOutput(dir, "rev-parse", "--abbrev-ref", "@{upstream}"); err != nil {
+		return 0, 0, errNoUpstream
+	}
+	counts, err := gitOutput(dir, "rev-list", "--left-right", "--count", "HEAD...@{upstream}")
+	if err != nil {
+		return 0, 0, err
+	}
+	return parseAheadBehind(counts)
+}
+
+// parseAheadBehind parses the "<ahead>\t<behind>" output of
+// git rev-list --left-right --count
+func parseAheadBehind(counts string) (ahead int, behind int, err error) {
+	fields := strings.Fields(counts)
+	if len(fields) != 2 {
+		return 0, 0, fmt.Errorf("unexpected rev-list output %q", counts)
+	}
+	if ahead, err = strconv.Atoi(fields[0]); err != nil {
+		return 0, 0, err
+	}
+	if behind, err = strconv.Atoi(fields[1]); err != nil {
+		return 0, 0, err
+	}
+	return ahead, behind, nil
+}
+
+// gitDir returns the git directory of the repository at dir, following the
+// gitdir: pointer of worktrees and submodules
+func gitDir(dir string) (string, error) {
+	path := filepath.Join(dir, ".git")
+	info, err := os.Stat(path)
+	if err != nil {
+		return "", err
+	}
+	if info.IsDir() {
+		return path, nil
+	}
+
+	contents, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	target := strings.TrimSpace(strings.TrimPrefix(string(contents), "gitdir:"))
+	if !filepath.IsAbs(target) {
+		target = filepath.Join(dir, target)
+	}
+	return target, nil
+}
+
+// isLocked reports whether another git process holds the index lock of the
+// repository at dir
+func isLocked(dir string) bool {
+	path, err := gitDir(dir)
+	if err != nil {
+		return false
+	}
+	_, err = os.Stat(filepath.Join(path, "index.lock"))
+	return err == nil
+}
+
+// unsafeState describes why the repository at dir is in a state where
+// running a mutating command is risky: mid-rebase, mid-merge or on a
+// detached HEAD. It returns "" for a repository on a branch.
+func unsafeState(dir string) string {
+	if path, err := gitDir(dir); err == nil {
+		for _, marker := range []string{"rebase-merge", "rebase-apply"} {
+			if _, err := os.Stat(filepath.Join(path, marker)); err == nil {
+				return "rebase in progress"
+			}
+		}
+		if _, err := os.Stat(filepath.Join(path, "MERGE_HEAD")); err == nil {
+			return "merge in progress"
+		}
+	}
+	if _, err := gitOutput(dir, "symbolic-ref", "-q", "HEAD"); err != nil {
+		return "detached HEAD"
+	}
+	return ""
+}
+
+// worktreeEnv returns GIT_DIR, GIT_COMMON_DIR and GIT_WORK_TREE settings
+// for dir when it is a linked worktree or submodule, whose .git is a file
+// pointing elsewhere, and nil otherwise
+func worktreeEnv(dir string) []string {
+	if info, err := os.Stat(filepath.Join(dir, ".git")); err != nil || info.IsDir() {
+		return nil
+	}
+	path, err := gitDir(dir)
+	if err != nil {
+		return nil
+	}
+	abs, err := filepath.Abs(dir)
+	if err != nil {
+		return nil
+	}
+	if path, err = filepath.Abs(path); err != nil {
+		return nil
+	}
+
+	env := []string{"GIT_DIR=" + path, "GIT_WORK_TREE=" + abs}
+	if common := commonDir(path); common != path {
+		env = append(env, "GIT_COMMON_DIR="+common)
+	}
+	return env
+}
+
+// commonDir returns the directory holding the refs and objects that the git
+// directory gitdir shares with other worktrees, which is gitdir itself for
+// a main worktree
+func commonDir(gitdir string) string {
+	common, err := os.ReadFile(filepath.Join(gitdir, "commondir"))
+	if err != nil {
+		return gitdir
+	}
+	dir := strings.TrimSpace(string(common))
+	if !filepath.IsAbs(dir) {
+		dir = filepath.Join(gitdir, dir)
+	}
+	return filepath.Clean(dir)
+}
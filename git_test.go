@@ -0,0 +1,99 @@
+package main
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+)
+
+// runGit runs git in dir, failing the test if it does not succeed
+func runGit(t *testing.T, dir string, args ...string) {
+	t.Helper()
+	process := exec.Command("git", args...)
+	process.Dir = dir
+	process.Env = append(os.Environ(),
+		"GIT_AUTHOR_NAME=test", "GIT_AUTHOR_EMAIL=test@example.com",
+		"GIT_COMMITTER_NAME=test", "GIT_COMMITTER_EMAIL=test@example.com",
+		"GIT_CONFIG_NOSYSTEM=1", "HOME="+dir)
+	if out, err := process.CombinedOutput(); err != nil {
+		t.Fatalf("git %q: %s\n%s", args, err, out)
+	}
+}
+
+// newTestRepo creates a repository with one commit at dir
+func newTestRepo(t *testing.T, dir string) {
+	t.Helper()
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	runGit(t, dir, "init", "-q")
+	runGit(t, dir, "commit", "-q", "--allow-empty", "-m", "init")
+}
+
+func TestParseAheadBehind(t *testing.T) {
+	tests := []struct {
+		counts        string
+		ahead, behind int
+		valid         bool
+	}{
+		{"0\t0", 0, 0, true},
+		{"3\t1\n", 3, 1, true},
+		{"12 7", 12, 7, true},
+		{"", 0, 0, false},
+		{"3", 0, 0, false},
+		{"3\t1\t4", 0, 0, false},
+		{"x\t1", 0, 0, false},
+		{"1\ty", 0, 0, false},
+	}
+	for _, test := range tests {
+		ahead, behind, err := parseAheadBehind(test.counts)
+		if (err == nil) != test.valid {
+			t.Errorf("parseAheadBehind(%q) error = %v", test.counts, err)
+			continue
+		}
+		if ahead != test.ahead || behind != test.behind {
+			t.Errorf("parseAheadBehind(%q) = %d, %d, want %d, %d", test.counts, ahead, behind, test.ahead, test.behind)
+		}
+	}
+}
+
+func TestWorktreeDetection(t *testing.T) {
+	root, err := filepath.EvalSymlinks(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	mainRepo, linked := filepath.Join(root, "main"), filepath.Join(root, "linked")
+	newTestRepo(t, mainRepo)
+	runGit(t, mainRepo, "worktree", "add", "-q", linked)
+
+	repos, err := discoverRepos(root, 1, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(repos) != 2 || repos[0] != "linked" || repos[1] != "main" {
+		t.Errorf("discovered %q, want the worktree and its main repo", repos)
+	}
+
+	if env := worktreeEnv(mainRepo); env != nil {
+		t.Errorf("worktreeEnv of the main repo = %q, want none", env)
+	}
+	want := map[string]bool{
+		"GIT_DIR=" + filepath.Join(mainRepo, ".git", "worktrees", "linked"): true,
+		"GIT_WORK_TREE=" + linked:                           true,
+		"GIT_COMMON_DIR=" + filepath.Join(mainRepo, ".git"): true,
+	}
+	env := worktreeEnv(linked)
+	for _, setting := range env {
+		if !want[setting] {
+			t.Errorf("unexpected %s", setting)
+		}
+		delete(want, setting)
+	}
+	for setting := range want {
+		t.Errorf("missing %s", setting)
+	}
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"log"
+	"os"
+	"strconv"
+
+	"github.com/saquib.mian/pgit/logwriter"
+	"github.com/saquib.mian/pgit/runner"
+)
+
+// runAfterHook runs script through the shell once all commands have
+// finished, with the run's counts exported as PGIT_* variables
+func runAfterHook(ctx context.Context, script string, counts statCounts) {
+	program, args := runner.ShellCommand(script)
+	cmd := Command{
+		Repo:    "after",
+		Command: program,
+		Args:    args,
+		Env: []string{
+			"PGIT_TOTAL=" + strconv.Itoa(counts.total()),
+			"PGIT_SUCCEEDED=" + strconv.Itoa(counts.succeeded),
+			"PGIT_FAILED=" + strconv.Itoa(counts.failed),
+			"PGIT_SKIPPED=" + strconv.Itoa(counts.skipped),
+		},
+	}
+
+	stdout := log.New(os.Stdout, "[after] ", 0)
+	stdoutWriter := logwriter.NewLogWriter(stdout)
+	stderrWriter := logwriter.NewLogWriter(stderrLogger(os.Stderr, cmd.Repo, "[after] "))
+	result := runCommand(ctx, stdoutWriter, stderrWriter, cmd, timeout)
+	stdoutWriter.Flush()
+	stderrWriter.Flush()
+
+	if !result.Success {
+		warnf("-after hook failed (exit code %d): %s", result.ExitCode, result.Error.Error())
+	}
+}
+
+// runPredicate runs script through the shell in the repository that cmd is
+// for, as -require and -only-if do, returning whether it succeeded along
+// with its combined output
+func runPredicate(ctx context.Context, cmd Command, script string) (bool, string) {
+	program, args := runner.ShellCommand(script)
+	check := Command{
+		Repo:       cmd.Repo,
+		WorkingDir: cmd.WorkingDir,
+		Command:    program,
+		Args:       args,
+		Env:        cmd.Env,
+	}
+
+	var output bytes.Buffer
+	result := runCommand(ctx, &output, &output, check, timeout)
+	return result.Success, output.String()
+}
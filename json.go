@@ -0,0 +1,43 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+)
+
+// jsonResult is the serialized form of a CommandResult
+type jsonResult struct {
+	Repo       string  `json:"repo"`
+	Command    string  `json:"command"`
+	Step       int     `json:"step"`
+	Success    bool    `json:"success"`
+	ExitCode   int     `json:"exit_code"`
+	Error      string  `json:"error,omitempty"`
+	Stdout     *string `json:"stdout,omitempty"`
+	Stderr     *string `json:"stderr,omitempty"`
+	Output     *string `json:"output,omitempty"`
+	DurationMs float64 `json:"duration_ms"`
+}
+
+// writeJSONResult writes result to w as a single line of JSON
+func writeJSONResult(w io.Writer, result CommandResult) error {
+	out := jsonResult{
+		Repo:       result.Command.Repo,
+		Command:    result.Command.String(),
+		Step:       result.Step + 1,
+		Success:    result.Success,
+		ExitCode:   result.ExitCode,
+		DurationMs: result.Duration.Seconds() * 1000,
+	}
+	if combinedOutput {
+		// everything was written to stdout
+		out.Output = &result.Stdout
+	} else {
+		out.Stdout, out.Stderr = &result.Stdout, &result.Stderr
+	}
+	if result.Error != nil {
+		out.Error = result.Error.Error()
+	}
+
+	return json.NewEncoder(w).Encode(out)
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"encoding/xml"
+	"fmt"
+	"os"
+	"sort"
+)
+
+type junitSuites struct {
+	XMLName xml.Name     `xml:"testsuites"`
+	Suites  []junitSuite `xml:"testsuite"`
+}
+
+type junitSuite struct {
+	Name     string      `xml:"name,attr"`
+	Tests    int         `xml:"tests,attr"`
+	Failures int         `xml:"failures,attr"`
+	Skipped  int         `xml:"skipped,attr"`
+	Time     float64     `xml:"time,attr"`
+	Cases    []junitCase `xml:"testcase"`
+}
+
+type junitCase struct {
+	Name      string        `xml:"name,attr"`
+	ClassName string        `xml:"classname,attr"`
+	Time      float64       `xml:"time,attr"`
+	Failure   *junitFailure `xml:"failure,omitempty"`
+	Skipped   *junitSkipped `xml:"skipped,omitempty"`
+	SystemOut string        `xml:"system-out,omitempty"`
+	SystemErr string        `xml:"system-err,omitempty"`
+}
+
+type junitFailure struct {
+	Message string `xml:"message,attr"`
+	Type    string `xml:"type,attr"`
+	Text    string `xml:",chardata"`
+}
+
+type junitSkipped struct {
+	Message string `xml:"message,attr"`
+}
+
+// writeJUnit writes results to path as a JUnit XML report with one test
+// case per repository
+func writeJUnit(path string, results []CommandResult) error {
+	sorted := make([]CommandResult, len(results))
+	copy(sorted, results)
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].Command.Repo < sorted[j].Command.Repo
+	})
+
+	suite := junitSuite{Name: "pgit", Tests: len(sorted)}
+	for _, result := range sorted {
+		testCase := junitCase{
+			Name:      result.Command.Repo,
+			ClassName: "pgit",
+			Time:      result.Duration.Seconds(),
+			SystemOut: result.Stdout,
+			SystemErr: result.Stderr,
+		}
+		if result.Skipped != "" {
+			suite.Skipped++
+			testCase.Skipped = &junitSkipped{Message: result.Skipped}
+		} else if !result.Success {
+			suite.Failures++
+			testCase.Failure = &junitFailure{
+				Message: result.Error.Error(),
+				Type:    fmt.Sprintf("exit code %d", result.ExitCode),
+				Text:    result.Stderr,
+			}
+		}
+		suite.Time += result.Duration.Seconds()
+		suite.Cases = append(suite.Cases, testCase)
+	}
+
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if _, err := f.WriteString(xml.Header); err != nil {
+		return err
+	}
+	encoder := xml.NewEncoder(f)
+	encoder.Indent("", "  ")
+	if err := encoder.Encode(junitSuites{Suites: []junitSuite{suite}}); err != nil {
+		return err
+	}
+	_, err = f.WriteString("\n")
+	return err
+}
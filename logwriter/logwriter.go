@@ -2,15 +2,26 @@ package logwriter
 
 import (
 	"bytes"
-	"io"
+	"fmt"
 	"log"
+	"unicode/utf8"
 )
 
 // LogWriter is an io.Writer that wraps a log.Logger
 type LogWriter struct {
-	Logger    *log.Logger
+	Logger *log.Logger
+	// MaxLines and MaxBytes, when positive, limit how much is logged. What
+	// comes after the limit is dropped, and Flush logs how many lines were.
+	MaxLines int
+	MaxBytes int
+	// OnTruncate, if set, is called the first time output is dropped
+	OnTruncate func()
+
 	buf       *bytes.Buffer
-	readLines string
+	lines     int
+	byteCount int
+	dropped   int
+	truncated bool
 }
 
 // NewLogWriter creates a new LogWriter that wraps a log.Logger
@@ -27,23 +38,65 @@ func (l *LogWriter) Write(p []byte) (n int, err error) {
 		return
 	}
 
-	err = l.Flush()
+	l.logLines()
 	return
 }
 
+// Flush logs everything written so far. A trailing partial line is logged
+// on its own line, so Flush is meant for when the writer's source has
+// finished writing.
 func (l *LogWriter) Flush() (err error) {
+	l.logLines()
+
+	if l.buf.Len() > 0 {
+		l.log(l.buf.String())
+		l.buf.Reset()
+	}
+	if l.dropped > 0 {
+		l.Logger.Print(fmt.Sprintf("... (truncated, %d more lines)", l.dropped))
+		l.dropped = 0
+	}
+	return nil
+}
+
+// logLines logs every complete line written so far, keeping a trailing
+// partial line until the rest of it is written
+func (l *LogWriter) logLines() {
 	for {
-		line, err := l.buf.ReadString('\n')
-		if err == io.EOF {
-			break
-		}
-		if err != nil {
-			return err
+		i := bytes.IndexByte(l.buf.Bytes(), '\n')
+		if i < 0 {
+			return
 		}
+		l.log(string(l.buf.Next(i + 1)))
+	}
+}
 
-		l.readLines += line
-		l.Logger.Print(line)
+func (l *LogWriter) log(line string) {
+	if (l.MaxLines > 0 && l.lines >= l.MaxLines) || (l.MaxBytes > 0 && l.byteCount >= l.MaxBytes) {
+		l.drop()
+		return
+	}
+	if l.MaxBytes > 0 && l.byteCount+len(line) > l.MaxBytes {
+		// the rest of the line is dropped, without splitting a rune
+		cut := l.MaxBytes - l.byteCount
+		for cut > 0 && !utf8.RuneStart(line[cut]) {
+			cut--
+		}
+		line = line[:cut]
+		l.drop()
+		// anything after this line is dropped too
+		l.byteCount = l.MaxBytes - len(line)
 	}
 
-	return nil
+	l.lines++
+	l.byteCount += len(line)
+	l.Logger.Print(line)
+}
+
+func (l *LogWriter) drop() {
+	l.dropped++
+	if !l.truncated && l.OnTruncate != nil {
+		l.OnTruncate()
+	}
+	l.truncated = true
 }
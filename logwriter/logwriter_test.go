@@ -0,0 +1,97 @@
+package logwriter
+
+import (
+	"bytes"
+	"log"
+	"testing"
+)
+
+func newTestWriter() (*LogWriter, *bytes.Buffer) {
+	out := &bytes.Buffer{}
+	return NewLogWriter(log.New(out, "[repo] ", 0)), out
+}
+
+func TestWriteSeveralLines(t *testing.T) {
+	w, out := newTestWriter()
+	w.Write([]byte("one\ntwo\nthree\n"))
+
+	want := "[repo] one\n[repo] two\n[repo] three\n"
+	if out.String() != want {
+		t.Errorf("got %q, want %q", out.String(), want)
+	}
+}
+
+func TestWriteLineSplitAcrossWrites(t *testing.T) {
+	w, out := newTestWriter()
+	w.Write([]byte("hel"))
+	if out.Len() != 0 {
+		t.Fatalf("partial line logged early: %q", out.String())
+	}
+	w.Write([]byte("lo\nwor"))
+	w.Write([]byte("ld\n"))
+
+	want := "[repo] hello\n[repo] world\n"
+	if out.String() != want {
+		t.Errorf("got %q, want %q", out.String(), want)
+	}
+}
+
+func TestFlushLogsTrailingPartialLine(t *testing.T) {
+	w, out := newTestWriter()
+	w.Write([]byte("done\nno newline"))
+	w.Flush()
+
+	want := "[repo] done\n[repo] no newline\n"
+	if out.String() != want {
+		t.Errorf("got %q, want %q", out.String(), want)
+	}
+
+	// nothing is left to log a second time
+	w.Flush()
+	if out.String() != want {
+		t.Errorf("second Flush logged again: %q", out.String())
+	}
+}
+
+func TestMaxLines(t *testing.T) {
+	w, out := newTestWriter()
+	w.MaxLines = 2
+	truncated := 0
+	w.OnTruncate = func() { truncated++ }
+	w.Write([]byte("1\n2\n3\n4\n"))
+	w.Flush()
+
+	want := "[repo] 1\n[repo] 2\n[repo] ... (truncated, 2 more lines)\n"
+	if out.String() != want {
+		t.Errorf("got %q, want %q", out.String(), want)
+	}
+	if truncated != 1 {
+		t.Errorf("OnTruncate called %d times, want 1", truncated)
+	}
+}
+
+func TestMaxBytes(t *testing.T) {
+	w, out := newTestWriter()
+	w.MaxBytes = 6
+	w.Write([]byte("abcd\nefgh\nijkl\n"))
+	w.Flush()
+
+	// "abcd\n" fits, one byte of the next line does, the rest is dropped
+	want := "[repo] abcd\n[repo] e\n[repo] ... (truncated, 2 more lines)\n"
+	if out.String() != want {
+		t.Errorf("got %q, want %q", out.String(), want)
+	}
+}
+
+func TestMaxBytesKeepsRunesWhole(t *testing.T) {
+	w, out := newTestWriter()
+	w.MaxBytes = 2
+	// "é" is two bytes, so only "a" fits
+	w.Write([]byte("aé\nb\n"))
+	w.Flush()
+
+	want := "[repo] a\n[repo] ... (truncated, 2 more lines)\n"
+	if out.String() != want {
+		t.Errorf("got %q, want %q", out.String(), want)
+	}
+}
@@ -1,176 +1,935 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"flag"
 	"fmt"
 	"io"
-	"io/ioutil"
-	"log"
 	"os"
 	"os/exec"
+	"os/signal"
 	"path/filepath"
+	"runtime"
 	"strings"
+	"syscall"
+	"text/template"
 	"time"
 
-	"github.com/saquib.mian/pgit/logwriter"
+	"github.com/saquib.mian/pgit/runner"
 )
 
+// build information, set with -ldflags "-X main.commit=... -X main.date=..."
+var (
+	commit = "unknown"
+	date   = "unknown"
+)
+
+const (
+	version    = "0.1"
+	ignorefile = ".pgitignore"
+)
+
+// runfiles are the config files looked for, in order of precedence
+var runfiles = []string{"prun.json", "prun.yaml", "prun.toml"}
+
+// verbosity levels
+const (
+	quietLevel   = -1
+	normalLevel  = 0
+	verboseLevel = 1
+)
+
+// exit codes
 const (
-	version = "0.1"
-	runfile = "prun.json"
-	timeout = time.Minute * 30
+	exitSuccess     = 0
+	exitFailed      = 1
+	exitUsage       = 2
+	exitNoRepos     = 3
+	exitTimedOut    = 124
+	exitInterrupted = 130
 )
 
 var (
 	maxconcurrency     = 4
+	workerLimits       = concurrencyList{maxconcurrency}
 	excludeDirectories string
+	maxdepth           = 1
+	timeout            = time.Minute * 30
+	execCommand        string
+	jsonOutput         bool
+	dryRun             bool
+	showSummary        bool
+	onlyDirty          bool
+	orderedOutput      bool
+	blockSeparator     string
+	headerFormat       string
+	blockHeader        *prefixFormat
+	colorMode          string
+	useColor           bool
+	streamOutput       bool
+	matchRepos         string
+	slowestCount       int
+	failFast           bool
+	failOnEmpty        bool
+	failOnOutput       bool
+	retryCount         int
+	retryBase          time.Duration
+	retryBackoff       string
+	retryMaxDelay      time.Duration
+	repeatCount        int
+	allDirectories     bool
+	reposFrom          string
+	verboseFlag        bool
+	quietFlag          bool
+	verbosity          = normalLevel
+	commandSteps       stringList
+	extraEnv           stringList
+	logDir             string
+	teeOutput          bool
+	showProgress       bool
+	showBranch         bool
+	onBranch           string
+	showVersion        bool
+	rootDir            = "."
+	includeSubmodules  bool
+	groupIdentical     bool
+	prefixStyle        string
+	listRepos          bool
+	dumpConfig         bool
+	statusMode         bool
+	junitFile          string
+	perHost            int
+	hosts              *hostLimiter
+	blockOutput        bool
+	ignoreCase         = runtime.GOOS == "windows" || runtime.GOOS == "darwin"
+	afterHook          string
+	deadline           time.Duration
+	seed               int64
+	quietSuccess       bool
+	maxFailures        int
+	combinedOutput     bool
+	shellMode          bool
+	interactive        bool
+	assumeYes          bool
+	onlyAhead          bool
+	newerThan          time.Duration
+	olderThan          time.Duration
+	changedSinceFlag   string
+	sinceCutoff        time.Time
+	onlyBehind         bool
+	prefixFormatFlag   string
+	skipLocked         bool
+	sortOutputBy       string
+	failuresFirst      bool
+	nullSeparated      bool
+	requireCmd         string
+	onlyIf             string
+	toleratePct        float64
+	safeMode           bool
+	logPrefixed        bool
+	useCache           bool
+	excludeRemote      string
+	gitBinary          string
+	watchMode          bool
+	maxLines           int
+	maxBytes           int
+	noPrefix           bool
+	killOnTruncate     bool
+	processGroup       bool
+	worktreeAware      bool
+	templateText       string
+	includeBare        bool
+	outputTemplate     *template.Template
+	refreshCache       bool
+	unsafeOK           bool
+	eventsFd           int
+	logFormat          string
+	events             *eventWriter
+	prefixTemplate     *prefixFormat
 )
 
 func init() {
-	flag.StringVar(&excludeDirectories, "exclude", "", "directories to exclude from the command")
-	flag.IntVar(&maxconcurrency, "n", 4, "number of commands to run at a time")
-	flag.Parse()
+	flag.StringVar(&excludeDirectories, "exclude", "", "comma-separated repository names, or paths relative to the root, to exclude from the command")
+	flag.BoolVar(&ignoreCase, "ignore-case", ignoreCase, "compare -exclude entries case-insensitively")
+	flag.StringVar(&excludeRemote, "exclude-remote", "", "exclude repositories whose origin URL matches this glob, or regex if prefixed with re:")
+	flag.StringVar(&matchRepos, "match", "", "only include repositories whose name matches this glob, or regex if prefixed with re:")
+	flag.Var(&workerLimits, "n", "number of commands to run at a time: a number, 0 for one per repository, or auto for one per CPU; a comma-separated list gives each step of a -cmd sequence its own count and runs the steps in phases")
+	flag.StringVar(&rootDir, "root", ".", "directory to search for repositories")
+	flag.BoolVar(&worktreeAware, "worktree-aware", false, "set GIT_DIR, GIT_COMMON_DIR and GIT_WORK_TREE for repositories that are linked worktrees")
+	flag.BoolVar(&includeBare, "include-bare", false, "also run in bare repositories, which are skipped by default")
+	flag.BoolVar(&includeSubmodules, "submodules", false, "also run in the submodules of each repository")
+	flag.BoolVar(&allDirectories, "all", false, "run in every directory, not just git repositories")
+	flag.BoolVar(&useCache, "cache", false, "reuse the last search for repositories below this root while the root directory is unchanged; repositories added deeper down are missed until -refresh-cache")
+	flag.BoolVar(&refreshCache, "refresh-cache", false, "search for repositories again and update the cache used by -cache")
+	flag.StringVar(&reposFrom, "from", "", "read the directories to run in from this file, one per line (- for stdin)")
+	flag.IntVar(&maxdepth, "depth", 1, "how many directory levels to search for repositories")
+	flag.Var(&commandSteps, "cmd", "arguments for one step of a sequence to run in each repository, split into words with shell-style quoting but no expansion; may be repeated")
+	flag.Var(&extraEnv, "env", "KEY=VALUE to set in the environment of each command; may be repeated")
+	flag.IntVar(&perHost, "per-host", 0, "maximum number of commands to run at once against the same remote host (0 for no limit)")
+	flag.StringVar(&gitBinary, "git", "git", "path to the git executable to use")
+	flag.StringVar(&execCommand, "exec", "", "program to run in each repository instead of git")
+	flag.BoolVar(&interactive, "i", false, "list the repos and ask for confirmation before running")
+	flag.BoolVar(&interactive, "interactive", false, "same as -i")
+	flag.BoolVar(&assumeYes, "yes", false, "with -i, run without asking; required when stdin is not a terminal")
+	flag.BoolVar(&shellMode, "shell", false, "run the command line through sh -c (cmd /c on Windows); it is not quoted, so never pass it untrusted input")
+	flag.BoolVar(&combinedOutput, "combined", false, "send stderr to the same stream as stdout, keeping the order they were written in")
+	flag.BoolVar(&quietSuccess, "quiet-success", false, "only show the output of repos whose command fails")
+	flag.Int64Var(&seed, "seed", 0, "shuffle the order repos are run in using this seed (0 runs them in sorted order); also seeds -retry-backoff exp jitter")
+	flag.DurationVar(&deadline, "deadline", 0, "how long the whole run may take before remaining commands are cancelled (0 for no limit)")
+	flag.DurationVar(&timeout, "timeout", time.Minute*30, "how long each command may run before it is killed (0 for no timeout)")
+	flag.BoolVar(&nullSeparated, "0", false, "separate repositories with NUL instead of newlines for -list and -from, like find -print0")
+	flag.BoolVar(&nullSeparated, "print0", false, "same as -0")
+	flag.BoolVar(&listRepos, "list", false, "print the repositories that would be run in, one per line, and exit")
+	flag.BoolVar(&dumpConfig, "dump-config", false, "print the configuration in effect after flags, PGIT_* variables and the runfile are combined, as JSON, and exit")
+	flag.BoolVar(&statusMode, "status", false, "print each repository's branch, ahead/behind counts and uncommitted changes, dirty ones first, and exit")
+	flag.BoolVar(&dryRun, "dry-run", false, "print the commands that would run without running them")
+	flag.BoolVar(&onlyAhead, "ahead", false, "only run in repositories with commits their upstream does not have")
+	flag.DurationVar(&newerThan, "newer-than", 0, "only run in repositories active within this long, going by the modification time of .git/logs/HEAD (0 for no limit)")
+	flag.DurationVar(&olderThan, "older-than", 0, "only run in repositories not active for at least this long, going by the modification time of .git/logs/HEAD (0 for no limit)")
+	flag.BoolVar(&onlyBehind, "behind", false, "only run in repositories missing commits from their upstream")
+	flag.StringVar(&changedSinceFlag, "changed-since", "", "only run in repositories whose latest commit is newer than this date (2006-01-02), timestamp or duration ago (72h)")
+	flag.BoolVar(&safeMode, "safe", false, "skip repositories that are mid-rebase, mid-merge or on a detached HEAD")
+	flag.BoolVar(&unsafeOK, "unsafe-ok", false, "with -safe, only warn about repositories in an unsafe state and run anyway")
+	flag.BoolVar(&skipLocked, "skip-locked", false, "skip repositories where another git process holds index.lock")
+	flag.BoolVar(&onlyDirty, "dirty", false, "only run in repositories with uncommitted changes")
+	flag.BoolVar(&blockOutput, "block", false, "print each repository's output as one block as soon as it finishes")
+	flag.StringVar(&sortOutputBy, "sort-output-by", "", "buffer output and print it sorted by name, duration (slowest first) or status (failures last)")
+	flag.BoolVar(&failuresFirst, "failures-first", false, "with -sort-output-by status, print failures first")
+	flag.BoolVar(&orderedOutput, "ordered", false, "buffer output and print it sorted by repository once all commands finish")
+	flag.StringVar(&blockSeparator, "separator", "", "with -block or -ordered, line to print between repositories' output (default a blank line)")
+	flag.StringVar(&headerFormat, "header", "", "with -block or -ordered, line to print before each repository's output, using the -prefix-format placeholders, e.g. '==== {repo} ({branch}) ===='")
+	flag.StringVar(&prefixFormatFlag, "prefix-format", defaultPrefixFormat, "template for the prefix of each output line, using {repo}, {branch}, {index} and {total}; empty for no prefix")
+	flag.StringVar(&prefixStyle, "prefix", "full", "label output with the repository's path from the root (full) or just its name (base), keeping enough of the path to tell repositories with the same name apart")
+	flag.StringVar(&colorMode, "color", "auto", "color repository prefixes: auto, always or never; auto honors NO_COLOR and CLICOLOR_FORCE")
+	flag.BoolVar(&noPrefix, "no-prefix", false, "write command output through unchanged: no prefixes, no \"-->\" line before each command, and the summary on stderr; best with -n 1 or -ordered, since output from different repositories cannot be told apart")
+	flag.IntVar(&maxLines, "max-lines", 0, "show at most this many lines of each repository's stdout and stderr (0 for no limit)")
+	flag.IntVar(&maxBytes, "max-bytes", 0, "show at most this many bytes of each repository's stdout and stderr (0 for no limit)")
+	flag.BoolVar(&killOnTruncate, "kill-on-truncate", false, "with -max-lines or -max-bytes, kill a repository's command once its output is truncated")
+	flag.BoolVar(&processGroup, "process-group", false, "run each command in its own process group, so the terminal's signals reach only pgit (not on Windows)")
+	flag.BoolVar(&streamOutput, "stream", false, "print each line of output as soon as it is written (always on; kept for compatibility)")
+	flag.StringVar(&logDir, "logdir", "", "write each repository's output to <logdir>/<repo>.log instead of the terminal")
+	flag.StringVar(&logFormat, "log-format", "text", "format of pgit's own messages: text, or json for one JSON object per line on stderr, where commands' stderr becomes \"output\" events, leaving stdout to command output")
+	flag.BoolVar(&logPrefixed, "log-prefix", false, "with -logdir, prefix lines in log files like terminal output")
+	flag.BoolVar(&teeOutput, "tee", false, "with -logdir, also write output to the terminal")
+	flag.BoolVar(&watchMode, "watch", false, "run again whenever a file in a repository's working tree that git does not ignore, its HEAD or one of its refs changes, until interrupted")
+	flag.BoolVar(&showProgress, "progress", false, "print how many commands have finished to stderr")
+	flag.IntVar(&slowestCount, "timings", 0, "print the N slowest repositories once all commands finish")
+	flag.BoolVar(&failFast, "fail-fast", false, "stop all commands as soon as one fails")
+	flag.BoolVar(&failOnEmpty, "fail-on-empty", false, "count a repository as failed if its commands succeed without writing to stdout")
+	flag.BoolVar(&failOnOutput, "fail-on-output", false, "count a repository as failed if its commands succeed but write to stdout")
+	flag.IntVar(&retryCount, "retry", 0, "run a failed command again up to this many times")
+	flag.DurationVar(&retryBase, "retry-delay", time.Second, "with -retry, how long to wait before retrying; the base delay with -retry-backoff exp")
+	flag.StringVar(&retryBackoff, "retry-backoff", "fixed", "with -retry, wait the same delay each time (fixed) or double it with random jitter (exp)")
+	flag.DurationVar(&retryMaxDelay, "retry-max-delay", time.Minute, "with -retry, the longest to wait before a retry (0 for no limit)")
+	flag.IntVar(&repeatCount, "repeat", 1, "run everything this many times and list how often each repository failed; with -fail-fast, stop after the first run with a failure")
+	flag.Float64Var(&toleratePct, "tolerate-pct", 0, "exit successfully if at most this percentage of the repos that ran failed")
+	flag.IntVar(&maxFailures, "max-failures", 0, "stop all commands once this many have failed (0 for no limit)")
+	flag.BoolVar(&verboseFlag, "v", false, "verbose: show command headers and timing")
+	flag.BoolVar(&quietFlag, "q", false, "quiet: only show command output and errors")
+	flag.StringVar(&onBranch, "on-branch", "", "only run in repositories currently on one of these comma-separated branches")
+	flag.BoolVar(&showBranch, "show-branch", false, "show each repository's current branch in headers and the summary")
+	flag.BoolVar(&groupIdentical, "group-identical", false, "print output shared by several repositories once, after all commands finish")
+	flag.StringVar(&onlyIf, "only-if", "", "shell command to run in each repository first; repositories where it fails are skipped as not applicable")
+	flag.StringVar(&requireCmd, "require", "", "shell command to run in each repository first; the main command is skipped where it fails")
+	flag.StringVar(&afterHook, "after", "", "shell command to run once all commands finish, with PGIT_TOTAL, PGIT_SUCCEEDED, PGIT_FAILED and PGIT_SKIPPED set")
+	flag.StringVar(&junitFile, "junit", "", "write a JUnit XML report of the results to this file")
+	flag.BoolVar(&showSummary, "summary", false, "print a table of results once all commands finish")
+	flag.IntVar(&eventsFd, "events-fd", -1, "write JSON progress events, one per line, to this open file descriptor")
+	flag.StringVar(&templateText, "template", "", "print each command result with this Go text/template instead of prefixed output; fields are Repo, Command, Step, Success, Skipped, ExitCode, Error, Duration, Stdout and Stderr")
+	flag.BoolVar(&jsonOutput, "json", false, "print one JSON object per command result instead of prefixed output")
+	flag.BoolVar(&showVersion, "version", false, "print version information and exit")
+	flag.Usage = usage
 }
 
-// Command is a representation of a program to run
-type Command struct {
-	WorkingDir string
-	Command    string
-	Args       []string
+// usage prints how to invoke pgit, followed by its flags
+func usage() {
+	out := flag.CommandLine.Output()
+	fmt.Fprintf(out, `usage: pgit [flags] <git subcommand> [args...]
+       pgit [flags] -exec <program> [args...]
+
+Runs a command in every git repository below the current directory.
+Flags are read up to the first argument that is not a flag, or up to --;
+everything after that is passed to the command verbatim.
+
+examples:
+  pgit fetch --all
+  pgit -n 2 -- log --oneline -n 5
+  pgit -n 8 -exclude legacy pull --rebase
+  pgit -exec make -- test
+  pgit -shell 'git fetch && git status --short'
+  pgit push origin {branch}
+
+In the command's arguments {repo} is replaced by each repository's path from
+the root, {name} by its directory name and {branch} by its current branch.
+Write {{ for a literal {.
+
+PGIT_EXCLUDE, PGIT_MATCH, PGIT_CONCURRENCY and PGIT_TIMEOUT give defaults for
+-exclude, -match, -n and -timeout. Flags override them, and they override the
+runfile (prun.json, prun.yaml or prun.toml).
+
+flags:
+`)
+	flag.PrintDefaults()
 }
 
+// Command is a program to run in a repository
+type Command = runner.Command
+
+// CommandResult is the outcome of running a Command. Skipped holds the
+// reason a command was not run at all, and Step is the index of the command
+// within the repository's sequence.
 type CommandResult struct {
-	Success bool
-	Error   error
-	Command Command
-}
+	Success  bool
+	Error    error
+	Command  Command
+	Skipped  string
+	Branch   string
+	Step     int
+	ExitCode int
+	Stdout   string
+	Stderr   string
+	Duration time.Duration
 
-func (c *Command) String() string {
-	return fmt.Sprintf("'%s %s' in '%s'", c.Command, strings.Join(c.Args, " "), c.WorkingDir)
+	display *bufferedOutput
+	// retries is how many times failed commands were run again
+	retries int
+	// stderrTail is the end of the repository's stderr, for the failure
+	// listing
+	stderrTail []string
 }
 
 func main() {
-	fmt.Printf("pgit v%s\n", version)
+	// parsing stops at the first non-flag argument or a --, which is
+	// dropped, so flag.Args() holds the command's own arguments untouched
+	flag.Parse()
+
+	if showVersion {
+		fmt.Printf("pgit v%s (commit %s, built %s)\n", version, commit, date)
+		os.Exit(exitSuccess)
+	}
+
+	if flag.NArg() == 0 && execCommand == "" && len(commandSteps) == 0 && !listRepos && !statusMode && !dumpConfig {
+		fmt.Fprintf(flag.CommandLine.Output(), "error: a git subcommand to run is required\n\n")
+		usage()
+		os.Exit(exitUsage)
+	}
+
+	runfile := findRunfile()
+	cfg, err := loadConfig(runfile)
+	if err != nil {
+		exitWithError(err)
+	}
+	maxconcurrency = workerLimits[0]
+	applyConfig(cfg)
+	if err := applyEnv(); err != nil {
+		exitWithError(err)
+	}
+	if dumpConfig {
+		if err := writeEffectiveConfig(os.Stdout, runfile, cfg); err != nil {
+			exitWithError(err)
+		}
+		os.Exit(exitSuccess)
+	}
+
+	if useColor, err = colorEnabled(colorMode); err != nil {
+		exitWithError(err)
+	}
+	for _, env := range extraEnv {
+		if !strings.Contains(env, "=") {
+			exitWithError(fmt.Errorf("invalid -env %q: must be KEY=VALUE", env))
+		}
+	}
+	if info, err := os.Stat(rootDir); err != nil {
+		exitWithError(err)
+	} else if !info.IsDir() {
+		exitWithError(fmt.Errorf("-root %s is not a directory", rootDir))
+	}
+	if eventsFd >= 0 {
+		if events, err = openEvents(eventsFd); err != nil {
+			exitWithError(err)
+		}
+	}
+	if toleratePct < 0 || toleratePct > 100 {
+		exitWithError(fmt.Errorf("-tolerate-pct must be between 0 and 100"))
+	}
+	if gitBinary != "git" || execCommand == "" {
+		if _, err := exec.LookPath(gitBinary); err != nil {
+			exitWithError(fmt.Errorf("cannot run git executable %q: %s", gitBinary, err.Error()))
+		}
+	}
+	// checked once here rather than failing identically in every repository.
+	// A program given as a path runs relative to each repository, so only
+	// names looked up in PATH can be checked.
+	if execCommand != "" && !shellMode && filepath.Base(execCommand) == execCommand {
+		if _, err := exec.LookPath(execCommand); err != nil {
+			exitWithError(fmt.Errorf("cannot find -exec program %q in PATH", execCommand))
+		}
+	}
+	if shellMode {
+		shell, _ := runner.ShellCommand("")
+		if _, err := exec.LookPath(shell); err != nil {
+			exitWithError(fmt.Errorf("cannot find the shell %q in PATH", shell))
+		}
+	}
+	if repeatCount < 1 {
+		exitWithError(fmt.Errorf("-repeat must be at least 1"))
+	}
+	if repeatCount > 1 && watchMode {
+		exitWithError(fmt.Errorf("-repeat and -watch cannot be used together"))
+	}
+	if failOnEmpty && failOnOutput {
+		exitWithError(fmt.Errorf("-fail-on-empty and -fail-on-output cannot be used together"))
+	}
+	if newerThan < 0 || olderThan < 0 {
+		exitWithError(fmt.Errorf("-newer-than and -older-than must not be negative"))
+	}
+	if retryCount < 0 {
+		exitWithError(fmt.Errorf("-retry must not be negative"))
+	}
+	if retryBackoff != "fixed" && retryBackoff != "exp" {
+		exitWithError(fmt.Errorf("invalid -retry-backoff value %q: must be fixed or exp", retryBackoff))
+	}
+	if maxFailures < 0 {
+		exitWithError(fmt.Errorf("-max-failures must not be negative"))
+	}
+	if perHost < 0 {
+		exitWithError(fmt.Errorf("-per-host must not be negative"))
+	} else if perHost > 0 {
+		hosts = &hostLimiter{limit: perHost}
+	}
+	switch sortOutputBy {
+	case "":
+	case "name", "duration", "status":
+		// sorting needs the output held until the end
+		if !groupIdentical {
+			orderedOutput = true
+		}
+	default:
+		exitWithError(fmt.Errorf("invalid -sort-output-by value %q: must be name, duration or status", sortOutputBy))
+	}
+	if diag, err = newDiagLogger(logFormat); err != nil {
+		exitWithError(err)
+	}
+	if prefixStyle != "full" && prefixStyle != "base" {
+		exitWithError(fmt.Errorf("invalid -prefix value %q: must be full or base", prefixStyle))
+	}
+	if prefixTemplate, err = parsePrefixFormat(prefixFormatFlag); err != nil {
+		exitWithError(fmt.Errorf("invalid -prefix-format: %s", err.Error()))
+	}
+	if changedSinceFlag != "" {
+		if sinceCutoff, err = parseSince(changedSinceFlag, time.Now()); err != nil {
+			exitWithError(fmt.Errorf("invalid -changed-since value %q: %s", changedSinceFlag, err.Error()))
+		}
+	}
+	if headerFormat != "" {
+		if blockHeader, err = parsePrefixFormat(headerFormat); err != nil {
+			exitWithError(fmt.Errorf("invalid -header: %s", err.Error()))
+		}
+	}
+	if noPrefix && (maxLines > 0 || maxBytes > 0) {
+		exitWithError(fmt.Errorf("-no-prefix cannot be used with -max-lines or -max-bytes"))
+	}
+	if noPrefix {
+		prefixTemplate, _ = parsePrefixFormat("")
+	}
+	if verboseFlag && quietFlag {
+		exitWithError(fmt.Errorf("-v and -q cannot be used together"))
+	}
+	if verboseFlag {
+		verbosity = verboseLevel
+	} else if quietFlag {
+		verbosity = quietLevel
+	}
+
+	if templateText != "" && jsonOutput {
+		exitWithError(fmt.Errorf("-template and -json cannot be used together"))
+	}
+	if templateText != "" {
+		if outputTemplate, err = parseOutputTemplate(templateText); err != nil {
+			exitWithError(fmt.Errorf("invalid -template: %s", err.Error()))
+		}
+	}
+
+	// everything but the results themselves goes to stderr in json mode,
+	// and with -no-prefix so stdout can be piped on
+	report := os.Stdout
+	if structuredOutput() || noPrefix {
+		report = os.Stderr
+	} else if verbosity > quietLevel && !listRepos && !statusMode && isTerminal(os.Stdout) {
+		fmt.Printf("pgit v%s\n", version)
+	}
 
 	additionalArgs := flag.Args()
+	program := gitBinary
+	if execCommand != "" {
+		program = execCommand
+	}
 
-	input := make(chan Command)
-	output := make(chan CommandResult)
+	// cancelled on interrupt so that running commands are killed
+	interrupted, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	runner.ForwardJobControl()
+	ctx, cancel := context.WithCancel(interrupted)
+	defer cancel()
+	if deadline > 0 {
+		var cancelDeadline context.CancelFunc
+		ctx, cancelDeadline = context.WithTimeout(ctx, deadline)
+		defer cancelDeadline()
+	}
 
-	// start workers
-	for i := 1; i <= maxconcurrency; i++ {
-		go worker(i, input, output)
+	var discovered []string
+	if reposFrom != "" {
+		discovered, err = loadRepoList(reposFrom, rootDir, !allDirectories)
+	} else if useCache || refreshCache {
+		discovered, err = cachedDiscoverRepos(rootDir, maxdepth, !allDirectories, refreshCache)
+	} else {
+		discovered, err = discoverRepos(rootDir, maxdepth, !allDirectories)
+	}
+	if err != nil {
+		exitWithError(err)
+	}
+	if includeSubmodules {
+		discovered = withSubmodules(rootDir, discovered)
+	}
+	if len(discovered) == 0 {
+		if verbosity > quietLevel {
+			diag.message(os.Stderr, "info", "", "no git repositories found in "+rootDir)
+		}
+		os.Exit(exitNoRepos)
+	}
+
+	if matchRepos != "" {
+		if _, err := matchPattern(matchRepos, ""); err != nil {
+			exitWithError(fmt.Errorf("invalid -match pattern: %s", err.Error()))
+		}
+	}
+	if excludeRemote != "" {
+		if _, err := matchPattern(excludeRemote, ""); err != nil {
+			exitWithError(fmt.Errorf("invalid -exclude-remote pattern: %s", err.Error()))
+		}
+	}
+
+	ignorePatterns, err := loadIgnoreFile(ignorefile)
+	if err != nil {
+		exitWithError(err)
+	}
+
+	// repos filtered out by their state are still reported as skipped
+	skipped := []CommandResult{}
+	skip := func(repo string, reason string) {
+		result := CommandResult{
+			Success: true,
+			Skipped: reason,
+			Command: Command{Repo: repo, WorkingDir: repoDir(repo)},
+		}
+		if showBranch {
+			result.Branch = displayBranch(repoDir(repo))
+		}
+		skipped = append(skipped, result)
 	}
 
 	repos := []string{}
-	dirs, _ := ioutil.ReadDir("./")
 	excludedDirs := strings.Split(excludeDirectories, ",")
-includedDirectories:
-	for _, dir := range dirs {
-		if !dir.IsDir() || strings.HasSuffix(dir.Name(), ".git") {
-			// not a directory
+	now := time.Now()
+	for _, repo := range discovered {
+		if !shouldInclude(repo, matchRepos, excludedDirs) {
+			continue
+		}
+		if isIgnored(repo, ignorePatterns) {
 			continue
 		}
-		if _, err := os.Stat(filepath.Join(dir.Name(), ".git")); os.IsNotExist(err) {
-			// not a git repo
+		if newerThan > 0 || olderThan > 0 {
+			active, err := activityTime(repoDir(repo))
+			if err != nil || !withinAge(active, now, newerThan, olderThan) {
+				continue
+			}
+		}
+		if excludeRemote != "" && remoteExcluded(repoDir(repo), excludeRemote) {
 			continue
 		}
 
-		// exclude certain dirs
-		for _, excludedDir := range excludedDirs {
-			if strings.EqualFold(dir.Name(), excludedDir) {
-				continue includedDirectories
+		// repos skipped in the runfile are excluded just like -exclude
+		if cfg.Repos[repo].Skip {
+			continue
+		}
+
+		if onBranch != "" && !onBranches(displayBranch(repoDir(repo)), onBranch) {
+			skip(repo, "branch")
+			continue
+		}
+
+		if onlyAhead || onlyBehind {
+			ahead, behind, err := aheadBehind(repoDir(repo))
+			if err == errNoUpstream {
+				skip(repo, "no upstream")
+				continue
+			} else if err != nil {
+				warnf("could not compare %s with its upstream: %s", repo, err.Error())
+				skip(repo, "no upstream")
+				continue
+			}
+			if !(onlyAhead && ahead > 0) && !(onlyBehind && behind > 0) {
+				skip(repo, "up to date")
+				continue
+			}
+		}
+
+		if changedSinceFlag != "" {
+			committed, err := lastCommitTime(repoDir(repo))
+			if err == errNoCommits {
+				skip(repo, "no commits")
+				continue
+			} else if err != nil {
+				warnf("could not read the latest commit of %s: %s", repo, err.Error())
+				skip(repo, "no commits")
+				continue
+			}
+			if !changedSince(committed, sinceCutoff) {
+				skip(repo, "unchanged")
+				continue
 			}
 		}
 
-		repos = append(repos, dir.Name())
+		repos = append(repos, repo)
 	}
 
-	// publish all commands to run
-	go func() {
+	if listRepos {
 		for _, repo := range repos {
-			cmd := Command{
-				WorkingDir: repo,
-				Command:    "git",
-				Args:       additionalArgs,
+			if nullSeparated {
+				fmt.Printf("%s\x00", repo)
+			} else {
+				fmt.Println(repo)
 			}
+		}
+		os.Exit(exitSuccess)
+	}
 
-			input <- cmd
+	if statusMode {
+		if printStatus(os.Stdout, collectStatus(repos, maxconcurrency)) > 0 {
+			os.Exit(exitFailed)
 		}
-		close(input)
-	}()
+		os.Exit(exitSuccess)
+	}
 
-	// wait for all commands to finish
-	failedCms := []CommandResult{}
-	for i := 0; i < len(repos); i++ {
-		result := <-output
-		if !result.Success {
-			failedCms = append(failedCms, result)
+	sequence := [][]string{}
+	for _, step := range commandSteps {
+		if shellMode {
+			// joined again for the shell, which does its own quoting
+			sequence = append(sequence, strings.Fields(step))
+			continue
+		}
+		words, err := splitWords(step)
+		if err != nil {
+			exitWithError(fmt.Errorf("invalid -cmd: %s", err.Error()))
 		}
+		sequence = append(sequence, words)
+	}
+	if len(additionalArgs) > 0 || len(sequence) == 0 {
+		sequence = append(sequence, additionalArgs)
 	}
 
-	if len(failedCms) > 0 {
-		fmt.Printf("error: %d command(s) failed\n", len(failedCms))
-		for _, result := range failedCms {
-			fmt.Printf("command failed: %s\n", result.Command.String())
+	if len(workerLimits) > len(sequence) {
+		exitWithError(fmt.Errorf("-n has %d counts but there are only %d steps", len(workerLimits), len(sequence)))
+	}
+
+	commands := [][]Command{}
+	for i, repo := range publishOrder(repos, seed) {
+		repoCfg := cfg.Repos[repo]
+		if filepath.IsAbs(repoCfg.WorkingDir) {
+			exitWithError(fmt.Errorf("workingdir for %s must be relative to the repository", repo))
+		}
+		repoTimeout := repoCfg.commandTimeout(timeout)
+		env := append(append([]string{}, extraEnv...), repoCfg.Env...)
+		if worktreeAware {
+			env = append(worktreeEnv(repoDir(repo)), env...)
+		}
+		steps := []Command{}
+		for _, args := range sequence {
+			step := Command{
+				Repo:       repo,
+				Index:      i + 1,
+				WorkingDir: filepath.Join(repoDir(repo), repoCfg.WorkingDir),
+				Command:    program,
+				Args:       expandArgs(args, repo),
+				Env:        env,
+				Timeout:    repoTimeout,
+			}
+			if shellMode {
+				// the words are joined as-is and parsed again by the shell
+				words := step.Args
+				if execCommand != "" {
+					words = append([]string{execCommand}, step.Args...)
+				}
+				step.Command, step.Args, step.Shell = strings.Join(words, " "), nil, true
+			}
+			steps = append(steps, step)
 		}
-		os.Exit(len(failedCms))
+		commands = append(commands, steps)
+	}
+	prefixTemplate.total = len(commands)
+	if prefixStyle == "base" {
+		// computed once over the whole run so every prefix is unique
+		names := []string{}
+		for _, steps := range commands {
+			names = append(names, steps[0].Repo)
+		}
+		prefixTemplate.names = shortNames(names)
+	}
+	if blockHeader != nil {
+		blockHeader.total = len(commands)
+		blockHeader.names = prefixTemplate.names
 	}
 
-	os.Exit(0)
-}
+	if interactive && !assumeYes && len(commands) > 0 {
+		if !isTerminal(os.Stdin) {
+			exitWithError(fmt.Errorf("-interactive needs -yes when stdin is not a terminal"))
+		}
+		if !confirmRun(report, repos, commands[0]) {
+			diag.message(report, "info", "", "cancelled")
+			os.Exit(exitSuccess)
+		}
+	}
 
-func worker(id int, input <-chan Command, output chan<- CommandResult) {
-	for cmd := range input {
-		stdout := log.New(os.Stdout, fmt.Sprintf("[%s] ", filepath.Base(cmd.WorkingDir)), 0)
-		stderr := log.New(os.Stderr, fmt.Sprintf("[%s] ", filepath.Base(cmd.WorkingDir)), 0)
+	if watchMode {
+		os.Exit(watchRepos(ctx, interrupted, commands, skipped, report))
+	}
+	if repeatCount > 1 {
+		os.Exit(repeatRuns(ctx, interrupted, commands, skipped, report, repeatCount))
+	}
+	code, _ := runBatch(ctx, interrupted, commands, skipped, report)
+	os.Exit(code)
+}
 
-		stdout.Printf("--> %s\n", cmd.String())
+// runBatch runs commands on a pool of workers and reports the results,
+// returning the exit code and every result. Cancelling parent stops the
+// batch; interrupted is the part of parent that is cancelled by signals.
+func runBatch(parent context.Context, interrupted context.Context, commands [][]Command, skipped []CommandResult, report io.Writer) (int, []CommandResult) {
+	ctx, cancel := context.WithCancel(parent)
+	defer cancel()
 
-		stdoutWriter := logwriter.NewLogWriter(stdout)
-		stderrWriter := logwriter.NewLogWriter(stderr)
-		result := runCommand(stdoutWriter, stderrWriter, cmd)
-		stdoutWriter.Flush()
-		stderrWriter.Flush()
+	started := time.Now()
+	diag.event("run-start", "", map[string]interface{}{"repos": len(commands), "skipped": len(skipped)})
+	var output <-chan CommandResult
+	if len(workerLimits) > 1 {
+		output = runPhases(ctx, commands, workerLimits)
+	} else {
+		output = runPool(ctx, commands, maxconcurrency, wholeSequence)
+	}
 
+	// wait for all commands to finish
+	stats := &runStats{}
+	for _, result := range skipped {
+		stats.record(result)
+	}
+	var prog *progress
+	if showProgress {
+		prog = newProgress(os.Stderr, len(skipped)+len(commands))
+	}
+	results := append([]CommandResult{}, skipped...)
+	rendered := []CommandResult{}
+	failedCms := []CommandResult{}
+	stopReason := ""
+	for result := range output {
+		if ctx.Err() == context.DeadlineExceeded && errors.As(result.Error, new(*runner.CancelledError)) {
+			// killed by -deadline rather than failing on its own
+			result.Success, result.Skipped = true, "deadline"
+		}
+		stats.record(result)
+		if prog != nil {
+			prog.update(stats.snapshot())
+		}
+		events.finished(result)
+		diag.event("repo-done", result.Command.Repo, resultFields(result))
+		if jsonOutput {
+			writeJSONResult(os.Stdout, result)
+		}
+		if outputTemplate != nil && bufferDisplay() {
+			rendered = append(rendered, result)
+		} else if outputTemplate != nil {
+			writeResultTemplate(result)
+		}
+		results = append(results, result)
 		if !result.Success {
-			stderr.Printf("error: %s\n", result.Error.Error())
+			failedCms = append(failedCms, result)
+			if failFast && ctx.Err() == nil {
+				diag.message(report, "error", "", "stopping after first failure")
+				cancel()
+			}
+			if maxFailures > 0 && stats.snapshot().failed == maxFailures && ctx.Err() == nil {
+				diag.message(report, "error", "", fmt.Sprintf("stopping after %d failures", maxFailures))
+				stopReason = "max-failures"
+				cancel()
+			}
 		}
+	}
 
-		output <- result
+	if prog != nil {
+		prog.finish()
+	}
+
+	// report repos that never started because the run was stopped early
+	if ctx.Err() == context.DeadlineExceeded {
+		stopReason = "deadline"
+		diag.message(report, "error", "", fmt.Sprintf("deadline of %s exceeded", deadline))
+	}
+	if stopReason != "" {
+		started := map[string]bool{}
+		for _, result := range results {
+			started[result.Command.Repo] = true
+		}
+		for _, steps := range commands {
+			if !started[steps[0].Repo] {
+				result := CommandResult{Success: true, Skipped: stopReason, Command: steps[0]}
+				stats.record(result)
+				results = append(results, result)
+			}
+		}
 	}
-}
 
-func runCommand(stdout io.Writer, stderr io.Writer, command Command) CommandResult {
-	process := exec.Command(command.Command, command.Args...)
-	process.Stdout = stdout
-	process.Stderr = stderr
-	if command.WorkingDir != "" {
-		process.Dir = command.WorkingDir
+	if bufferDisplay() {
+		sortResults(results, sortOutputBy, failuresFirst)
+		sortResults(rendered, sortOutputBy, failuresFirst)
+	}
+	for _, result := range rendered {
+		writeResultTemplate(result)
+	}
+	if groupIdentical {
+		printGrouped(os.Stdout, os.Stderr, results)
+	} else if orderedOutput {
+		for _, result := range results {
+			if result.display != nil {
+				writeBlock(os.Stdout, os.Stderr, result)
+			}
+		}
 	}
 
-	if err := process.Start(); err != nil {
-		return CommandResult{Error: err, Command: command}
+	if showSummary {
+		printSummary(report, results)
+	}
+	if slowestCount > 0 {
+		printTimings(report, results, slowestCount)
+	}
+	if junitFile != "" {
+		if err := writeJUnit(junitFile, results); err != nil {
+			diag.message(os.Stderr, "error", "", "could not write JUnit report: "+err.Error())
+		}
 	}
 
-	timedOut := false
-	timer := time.NewTimer(timeout)
-	go func(timer *time.Timer, process *exec.Cmd) {
-		for _ = range timer.C {
-			process.Process.Signal(os.Kill)
-			timedOut = true
-			break
+	if len(failedCms) > 0 {
+		diag.message(report, "error", "", fmt.Sprintf("%d command(s) failed", len(failedCms)))
+	}
+	if !jsonLogs() {
+		// repo-done events carry the same details in JSON
+		for _, result := range failedCms {
+			fmt.Fprintf(report, "command failed (step %d, exit code %d): %s\n", result.Step+1, result.ExitCode, result.Command.String())
+			for _, line := range result.stderrTail {
+				fmt.Fprintf(report, "    %s\n", line)
+			}
 		}
-	}(timer, process)
+	}
+
+	if afterHook != "" && interrupted.Err() == nil {
+		runAfterHook(interrupted, afterHook, stats.snapshot())
+	}
 
-	if err := process.Wait(); err != nil {
-		if timedOut {
-			err = fmt.Errorf("process timed out: %s", command.String())
-		} else if _, ok := err.(*exec.ExitError); ok {
-			err = fmt.Errorf("exited with non-zero exit code")
+	// the final line is kept stable for monitoring to parse
+	exit := func(code int) (int, []CommandResult) {
+		counts := stats.snapshot()
+		if jsonLogs() {
+			diag.event("summary", "", map[string]interface{}{
+				"total":    counts.total(),
+				"ok":       counts.succeeded,
+				"failed":   counts.failed,
+				"skipped":  counts.skipped,
+				"retried":  counts.retried,
+				"duration": time.Since(started).Seconds(),
+				"exit":     code,
+			})
+		} else if verbosity > quietLevel {
+			fmt.Fprintln(os.Stderr, counts.line(time.Since(started)))
 		}
-		return CommandResult{Error: err, Command: command}
+		return code, results
+	}
+	if interrupted.Err() != nil {
+		diag.message(report, "error", "", "interrupted")
+		return exit(exitInterrupted)
+	}
+	if stopReason == "deadline" {
+		return exit(exitTimedOut)
+	}
+	if len(failedCms) > 0 && tolerated(stats.snapshot(), toleratePct) {
+		diag.message(report, "info", "", fmt.Sprintf("failures are within the %g%% tolerance", toleratePct))
+		return exit(exitSuccess)
 	}
+	for _, result := range failedCms {
+		var timeoutErr *runner.TimeoutError
+		if errors.As(result.Error, &timeoutErr) {
+			return exit(exitTimedOut)
+		}
+	}
+	if len(failedCms) > 0 {
+		return exit(exitFailed)
+	}
+
+	return exit(exitSuccess)
+}
+
+// tolerated reports whether failed repos make up at most pct percent of the
+// repos that ran
+func tolerated(counts statCounts, pct float64) bool {
+	ran := counts.succeeded + counts.failed
+	return float64(counts.failed)*100 <= pct*float64(ran)
+}
+
+// structuredOutput reports whether results are written to stdout as JSON
+// or a -template instead of as prefixed output
+func structuredOutput() bool {
+	return jsonOutput || outputTemplate != nil
+}
+
+// writeResultTemplate writes result to stdout with the -template
+func writeResultTemplate(result CommandResult) {
+	if err := writeTemplateResult(os.Stdout, outputTemplate, result); err != nil {
+		diag.message(os.Stderr, "error", result.Command.Repo, "could not render -template for "+result.Command.Repo+": "+err.Error())
+	}
+}
+
+// bufferDisplay reports whether repositories' output is held until all
+// commands finish rather than written as it arrives
+func bufferDisplay() bool {
+	return orderedOutput || groupIdentical || blockOutput || quietSuccess
+}
+
+// captureOutput reports whether results need the raw output of commands
+func captureOutput() bool {
+	return groupIdentical || junitFile != "" || failOnEmpty || failOnOutput
+}
+
+// checkOutput fails a successful result whose stdout breaks -fail-on-empty
+// or -fail-on-output
+func checkOutput(result CommandResult, stdout string) CommandResult {
+	if !result.Success || result.Skipped != "" {
+		return result
+	}
+	if failOnEmpty && stdout == "" {
+		result.Success = false
+		result.Error = fmt.Errorf("exited successfully but wrote no output")
+	} else if failOnOutput && stdout != "" {
+		result.Success = false
+		result.Error = fmt.Errorf("exited successfully but wrote output")
+	}
+	return result
+}
+
+// repoDir returns the path of repo, which is relative to the scan root
+// unless it is absolute
+func repoDir(repo string) string {
+	return repoPath(rootDir, repo)
+}
 
-	return CommandResult{Success: true, Command: command}
+// exitWithError reports an error that prevented pgit from running at all
+func exitWithError(err error) {
+	fmt.Fprintf(os.Stderr, "error: %s\n", err.Error())
+	os.Exit(exitUsage)
 }
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"flag"
+	"reflect"
+	"testing"
+)
+
+func TestDoubleDashSeparator(t *testing.T) {
+	saved := workerLimits
+	defer func() { workerLimits = saved }()
+
+	parseTestFlags(t, "-n", "2", "--", "log", "--oneline", "-n", "5")
+
+	if !reflect.DeepEqual(workerLimits, concurrencyList{2}) {
+		t.Errorf("-n = %v, want 2", workerLimits)
+	}
+	want := []string{"log", "--oneline", "-n", "5"}
+	if !reflect.DeepEqual(flag.Args(), want) {
+		t.Errorf("args = %q, want %q", flag.Args(), want)
+	}
+}
+
+func TestTolerated(t *testing.T) {
+	tests := []struct {
+		succeeded, failed, skipped int
+		pct                        float64
+		want                       bool
+	}{
+		{4, 0, 0, 0, true},
+		{3, 1, 0, 0, false},
+		{3, 1, 0, 25, true},
+		{3, 1, 0, 24.9, false},
+		{1, 2, 0, 66.6, false},
+		{1, 2, 0, 66.7, true},
+		{0, 4, 0, 100, true},
+		{0, 4, 0, 99.9, false},
+		// skipped repos did not run, so they do not dilute failures
+		{3, 1, 96, 25, true},
+		{3, 1, 96, 5, false},
+		{0, 0, 0, 0, true},
+	}
+	for _, test := range tests {
+		counts := statCounts{succeeded: test.succeeded, failed: test.failed, skipped: test.skipped}
+		if got := tolerated(counts, test.pct); got != test.want {
+			t.Errorf("tolerated(%d ok, %d failed, %d skipped, %g%%) = %t, want %t",
+				test.succeeded, test.failed, test.skipped, test.pct, got, test.want)
+		}
+	}
+}
+
+// parseTestFlags parses args as pgit's command line for the rest of the
+// test, on a copy of the flags so that which ones were set does not leak
+// into other tests. The flag variables themselves are shared.
+func parseTestFlags(t *testing.T, args ...string) {
+	t.Helper()
+	saved := flag.CommandLine
+	t.Cleanup(func() { flag.CommandLine = saved })
+
+	flags := flag.NewFlagSet("pgit", flag.ContinueOnError)
+	saved.VisitAll(func(f *flag.Flag) {
+		flags.Var(f.Value, f.Name, f.Usage)
+	})
+	if err := flags.Parse(args); err != nil {
+		t.Fatal(err)
+	}
+	flag.CommandLine = flags
+}
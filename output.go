@@ -0,0 +1,167 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// bufferedOutput holds the prefixed output of a single repository so that it
+// can be displayed after the command completes
+type bufferedOutput struct {
+	stdout bytes.Buffer
+	stderr bytes.Buffer
+}
+
+// writeTo copies the buffered output to the given streams
+func (b *bufferedOutput) writeTo(stdout io.Writer, stderr io.Writer) {
+	stdout.Write(b.stdout.Bytes())
+	stderr.Write(b.stderr.Bytes())
+}
+
+// printGrouped writes the stdout of results once for each distinct output,
+// headed by the repositories that produced it. Each repository's stderr is
+// still written individually.
+func printGrouped(stdout io.Writer, stderr io.Writer, results []CommandResult) {
+	groups := map[[sha256.Size]byte][]CommandResult{}
+	order := [][sha256.Size]byte{}
+	for _, result := range results {
+		if result.display == nil {
+			// never ran
+			continue
+		}
+
+		key := sha256.Sum256([]byte(result.Stdout))
+		if _, ok := groups[key]; !ok {
+			order = append(order, key)
+		}
+		groups[key] = append(groups[key], result)
+	}
+
+	for _, key := range order {
+		group := groups[key]
+		repos := []string{}
+		for _, result := range group {
+			repos = append(repos, result.Command.Repo)
+		}
+
+		fmt.Fprintf(stdout, "==> %s\n", strings.Join(repos, ", "))
+		io.WriteString(stdout, group[0].Stdout)
+		for _, result := range group {
+			stderr.Write(result.display.stderr.Bytes())
+		}
+	}
+}
+
+// displayMu keeps blocks of output from different repositories from
+// interleaving, and guards blocksWritten
+var displayMu sync.Mutex
+
+// blocksWritten counts the blocks written so far, so that -separator goes
+// only between them
+var blocksWritten int
+
+// writeBlock writes the buffered output of result as one uninterrupted
+// block, after the -separator line if a block came before it and the
+// -header line if one is set
+func writeBlock(stdout io.Writer, stderr io.Writer, result CommandResult) {
+	displayMu.Lock()
+	defer displayMu.Unlock()
+	if blocksWritten > 0 {
+		fmt.Fprintln(stdout, blockSeparator)
+	}
+	if blockHeader != nil {
+		fmt.Fprintln(stdout, blockHeader.render(result.Command))
+	}
+	result.display.writeTo(stdout, stderr)
+	blocksWritten++
+}
+
+// logFileNames turns a repository path into a single file name
+var logFileNames = strings.NewReplacer("/", "_", "\\", "_")
+
+// createRepoLog creates (or truncates) the log file for repo inside dir, or
+// opens it for appending when appendLog is set
+func createRepoLog(dir string, repo string, appendLog bool) (*os.File, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	name := filepath.Join(dir, logFileNames.Replace(repo)+".log")
+	if appendLog {
+		return os.OpenFile(name, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	}
+	return os.Create(name)
+}
+
+// sortResults orders results for display by name, by duration (slowest
+// first) or by status, with failures last unless failuresFirst is set.
+// Ties are broken by name.
+func sortResults(results []CommandResult, by string, failuresFirst bool) {
+	sort.Slice(results, func(i, j int) bool {
+		return results[i].Command.Repo < results[j].Command.Repo
+	})
+
+	switch by {
+	case "duration":
+		sort.SliceStable(results, func(i, j int) bool {
+			return results[i].Duration > results[j].Duration
+		})
+	case "status":
+		rank := func(result CommandResult) int {
+			switch {
+			case !result.Success:
+				if failuresFirst {
+					return -1
+				}
+				return 2
+			case result.Skipped != "":
+				return 1
+			}
+			return 0
+		}
+		sort.SliceStable(results, func(i, j int) bool {
+			return rank(results[i]) < rank(results[j])
+		})
+	}
+}
+
+// failureTailLines is how many lines of stderr are shown for each failure
+// at the end of a run
+const failureTailLines = 10
+
+// tailBuffer is an io.Writer that keeps only the last n lines written to it
+type tailBuffer struct {
+	n       int
+	lines   []string
+	partial string
+}
+
+func (t *tailBuffer) Write(p []byte) (int, error) {
+	parts := strings.Split(t.partial+string(p), "\n")
+	t.partial = parts[len(parts)-1]
+	for _, line := range parts[:len(parts)-1] {
+		t.lines = append(t.lines, line)
+		if len(t.lines) > t.n {
+			t.lines = t.lines[1:]
+		}
+	}
+	return len(p), nil
+}
+
+// tail returns the last n lines, including a final unterminated one
+func (t *tailBuffer) tail() []string {
+	lines := append([]string{}, t.lines...)
+	if t.partial != "" {
+		lines = append(lines, t.partial)
+	}
+	if len(lines) > t.n {
+		lines = lines[len(lines)-t.n:]
+	}
+	return lines
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"context"
+	"sort"
+
+	"github.com/saquib.mian/pgit/runner"
+)
+
+// runPool runs each repository's sequence through runner.Run on up to limit
+// workers, or one per repository when limit is 0. The returned channel gets
+// each result as its repository finishes and is closed once all have.
+// Repositories not yet started when ctx is cancelled send nothing. No
+// goroutines are started per repository beyond the workers, so large runs
+// stay bounded by limit. phase is passed on to runSteps.
+func runPool(ctx context.Context, commands [][]Command, limit int, phase int) <-chan CommandResult {
+	output := make(chan CommandResult)
+	go func() {
+		defer close(output)
+		// every sequence has a command, so Run does not fail
+		runner.Run(ctx, runner.Options{
+			Commands:    commands,
+			Concurrency: limit,
+			RunSequence: func(ctx context.Context, steps []Command) runner.Result {
+				result := runSteps(ctx, steps, phase)
+				output <- result
+				return runner.Result{
+					Success:  result.Success,
+					Error:    result.Error,
+					Command:  result.Command,
+					Step:     result.Step,
+					ExitCode: result.ExitCode,
+					Stdout:   result.Stdout,
+					Stderr:   result.Stderr,
+					Duration: result.Duration,
+				}
+			},
+		})
+	}()
+	return output
+}
+
+// runPhases runs sequences one step at a time across all repositories: every
+// repository finishes step 1 before any starts step 2. Step i runs on
+// limits[i] workers, or the last limit when there are fewer limits than
+// steps. A repository is checked against -dirty and the like once, before
+// its first step, and one that fails or is skipped drops out of later phases.
+// Each repository's result is sent once, with the output, durations and
+// retries of all its steps.
+func runPhases(ctx context.Context, commands [][]Command, limits []int) <-chan CommandResult {
+	output := make(chan CommandResult)
+	go func() {
+		defer close(output)
+
+		sequences := map[string][]Command{}
+		results := map[string]CommandResult{}
+		remaining := commands
+		for phase := 0; len(remaining) > 0; phase++ {
+			limit := limits[len(limits)-1]
+			if phase < len(limits) {
+				limit = limits[phase]
+			}
+
+			steps := [][]Command{}
+			for _, sequence := range remaining {
+				sequences[sequence[0].Repo] = sequence
+				steps = append(steps, sequence[phase:phase+1])
+			}
+
+			next := [][]Command{}
+			for result := range runPool(ctx, steps, limit, phase) {
+				repo := result.Command.Repo
+				result.Step = phase
+				result = mergePhase(results[repo], result)
+
+				sequence := sequences[repo]
+				if result.Success && result.Skipped == "" && phase+1 < len(sequence) {
+					results[repo] = result
+					next = append(next, sequence)
+					continue
+				}
+				delete(results, repo)
+				output <- finishRepo(checkOutput(result, result.Stdout))
+			}
+
+			// keep the publish order for the next phase
+			sort.Slice(next, func(i, j int) bool {
+				return next[i][0].Index < next[j][0].Index
+			})
+			remaining = next
+		}
+	}()
+	return output
+}
+
+// mergePhase adds the output, durations and retries of a repository's earlier
+// phases, merged into previous, to the result of its latest phase
+func mergePhase(previous CommandResult, result CommandResult) CommandResult {
+	result.Duration += previous.Duration
+	result.retries += previous.retries
+	result.Stdout = previous.Stdout + result.Stdout
+	result.Stderr = previous.Stderr + result.Stderr
+	if previous.display != nil {
+		if result.display != nil {
+			previous.display.stdout.Write(result.display.stdout.Bytes())
+			previous.display.stderr.Write(result.display.stderr.Bytes())
+		}
+		result.display = previous.display
+	}
+	tail := append(append([]string{}, previous.stderrTail...), result.stderrTail...)
+	if len(tail) > failureTailLines {
+		tail = tail[len(tail)-failureTailLines:]
+	}
+	result.stderrTail = tail
+	return result
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// TestRunPhasesKeepsEarlierOutput runs a two-step sequence a step at a time
+// and checks that the first step's output survives into the result and the
+// log file
+func TestRunPhasesKeepsEarlierOutput(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("needs sh")
+	}
+	defer func(json, ordered, tee bool, dir string, prefix *prefixFormat) {
+		jsonOutput, orderedOutput, teeOutput, logDir, prefixTemplate = json, ordered, tee, dir, prefix
+	}(jsonOutput, orderedOutput, teeOutput, logDir, prefixTemplate)
+	prefixTemplate, _ = parsePrefixFormat(defaultPrefixFormat)
+
+	dir := t.TempDir()
+	sequence := []Command{
+		{Repo: "repo", Index: 1, WorkingDir: dir, Command: "sh", Args: []string{"-c", "echo STEP1; echo ERR1 >&2"}},
+		{Repo: "repo", Index: 1, WorkingDir: dir, Command: "sh", Args: []string{"-c", "echo STEP2; echo ERR2 >&2"}},
+	}
+	run := func() CommandResult {
+		t.Helper()
+		var results []CommandResult
+		for result := range runPhases(context.Background(), [][]Command{sequence}, []int{2, 1}) {
+			results = append(results, result)
+		}
+		if len(results) != 1 || !results[0].Success {
+			t.Fatalf("results = %+v, want one success", results)
+		}
+		return results[0]
+	}
+
+	jsonOutput, orderedOutput, teeOutput, logDir = true, false, false, ""
+	result := run()
+	if result.Stdout != "STEP1\nSTEP2\n" || result.Stderr != "ERR1\nERR2\n" {
+		t.Errorf("-json: stdout %q, stderr %q, want both steps'", result.Stdout, result.Stderr)
+	}
+	if result.Step != 1 {
+		t.Errorf("-json: step %d, want 1", result.Step)
+	}
+	if tail := strings.Join(result.stderrTail, ","); tail != "ERR1,ERR2" {
+		t.Errorf("-json: stderr tail %q, want ERR1,ERR2", tail)
+	}
+
+	jsonOutput, orderedOutput, teeOutput, logDir = false, true, true, filepath.Join(dir, "logs")
+	result = run()
+	if result.display == nil {
+		t.Fatal("-ordered: no output held for display")
+	}
+	for _, want := range []string{"STEP1", "STEP2"} {
+		if !strings.Contains(result.display.stdout.String(), want) {
+			t.Errorf("-ordered: display %q is missing %s", result.display.stdout.String(), want)
+		}
+	}
+	logged, err := os.ReadFile(filepath.Join(logDir, "repo.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"STEP1", "ERR1", "STEP2", "ERR2"} {
+		if !strings.Contains(string(logged), want) {
+			t.Errorf("log file %q is missing %s", logged, want)
+		}
+	}
+}
+
+// BenchmarkRunPool runs b.N no-op commands on a few workers, failing if the
+// number of goroutines grows with the number of repositories rather than
+// staying bounded by the workers
+func BenchmarkRunPool(b *testing.B) {
+	if _, err := exec.LookPath("true"); err != nil {
+		b.Skip("needs true")
+	}
+	defer func(saved bool) { jsonOutput = saved }(jsonOutput)
+	jsonOutput = true
+
+	const limit = 4
+	dir := b.TempDir()
+	commands := make([][]Command, b.N)
+	for i := range commands {
+		commands[i] = []Command{{Repo: strconv.Itoa(i), Index: i + 1, WorkingDir: dir, Command: "true"}}
+	}
+
+	before := runtime.NumGoroutine()
+	peak := before
+	b.ResetTimer()
+	for result := range runPool(context.Background(), commands, limit, wholeSequence) {
+		if !result.Success {
+			b.Fatalf("%s: %v", result.Command.Repo, result.Error)
+		}
+		if n := runtime.NumGoroutine(); n > peak {
+			peak = n
+		}
+	}
+	b.StopTimer()
+
+	// the workers, the publisher, the closer and a few per running command
+	// for its output pipes
+	if bound := before + limit*4 + 2; peak > bound {
+		b.Errorf("%d goroutines for %d commands on %d workers, want at most %d", peak, b.N, limit, bound)
+	}
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// defaultPrefixFormat labels each line with the repository in brackets
+const defaultPrefixFormat = "[{repo}] "
+
+// prefixFormat is a parsed -prefix-format template. Literal text and
+// placeholders alternate in parts, starting with literal text.
+type prefixFormat struct {
+	parts []string
+	// total is the number of repos in the run, for {total}
+	total int
+	// names are the unique short names used for {repo} with -prefix base
+	names map[string]string
+}
+
+// prefixPlaceholders are the names that may appear in braces
+var prefixPlaceholders = map[string]bool{"repo": true, "branch": true, "index": true, "total": true}
+
+// parsePrefixFormat splits format into literal text and placeholders
+func parsePrefixFormat(format string) (*prefixFormat, error) {
+	parsed := &prefixFormat{}
+	rest := format
+	for {
+		start := strings.Index(rest, "{")
+		if start < 0 {
+			parsed.parts = append(parsed.parts, rest)
+			return parsed, nil
+		}
+		end := strings.Index(rest[start:], "}")
+		if end < 0 {
+			return nil, fmt.Errorf("unclosed { in %q", format)
+		}
+		name := rest[start+1 : start+end]
+		if !prefixPlaceholders[name] {
+			return nil, fmt.Errorf("unknown placeholder {%s} in %q", name, format)
+		}
+		parsed.parts = append(parsed.parts, rest[:start], name)
+		rest = rest[start+end+1:]
+	}
+}
+
+// render fills in the placeholders for cmd's repository
+func (p *prefixFormat) render(cmd Command) string {
+	var b strings.Builder
+	for i, part := range p.parts {
+		if i%2 == 0 {
+			b.WriteString(part)
+			continue
+		}
+		switch part {
+		case "repo":
+			name := filepath.ToSlash(cmd.Repo)
+			if short, ok := p.names[cmd.Repo]; ok && prefixStyle == "base" {
+				name = short
+			} else if prefixStyle == "base" {
+				name = filepath.Base(cmd.Repo)
+			}
+			b.WriteString(name)
+		case "branch":
+			b.WriteString(displayBranch(repoDir(cmd.Repo)))
+		case "index":
+			b.WriteString(strconv.Itoa(cmd.Index))
+		case "total":
+			b.WriteString(strconv.Itoa(p.total))
+		}
+	}
+	return b.String()
+}
+
+// shortNames gives each repo the fewest trailing path components that no
+// other repo ends with, so that -prefix base stays unambiguous when two
+// repositories share a name: a/lib and b/lib become a/lib and b/lib, while
+// a lone c stays c.
+func shortNames(repos []string) map[string]string {
+	parts := map[string][]string{}
+	longest := 0
+	for _, repo := range repos {
+		parts[repo] = strings.Split(filepath.ToSlash(repo), "/")
+		if len(parts[repo]) > longest {
+			longest = len(parts[repo])
+		}
+	}
+
+	// suffix is the last n components of repo, or all of them
+	suffix := func(repo string, n int) string {
+		p := parts[repo]
+		if n > len(p) {
+			n = len(p)
+		}
+		return strings.Join(p[len(p)-n:], "/")
+	}
+
+	names := map[string]string{}
+	for n := 1; n <= longest && len(names) < len(parts); n++ {
+		counts := map[string]int{}
+		for repo := range parts {
+			counts[suffix(repo, n)]++
+		}
+		for repo := range parts {
+			if _, named := names[repo]; !named && counts[suffix(repo, n)] == 1 {
+				names[repo] = suffix(repo, n)
+			}
+		}
+	}
+	for repo := range parts {
+		if _, named := names[repo]; !named {
+			// listed twice
+			names[repo] = filepath.ToSlash(repo)
+		}
+	}
+	return names
+}
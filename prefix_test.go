@@ -0,0 +1,53 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestShortNames(t *testing.T) {
+	tests := []struct {
+		repos []string
+		want  map[string]string
+	}{
+		{
+			[]string{"a/lib", "b/lib", "c"},
+			map[string]string{"a/lib": "a/lib", "b/lib": "b/lib", "c": "c"},
+		},
+		{
+			[]string{"x/a/lib", "y/a/lib", "team/api"},
+			map[string]string{"x/a/lib": "x/a/lib", "y/a/lib": "y/a/lib", "team/api": "api"},
+		},
+		{
+			// a top-level repo is named by its whole path
+			[]string{"lib", "vendor/lib"},
+			map[string]string{"lib": "lib", "vendor/lib": "vendor/lib"},
+		},
+		{
+			[]string{"one/api", "two/web"},
+			map[string]string{"one/api": "api", "two/web": "web"},
+		},
+	}
+	for _, test := range tests {
+		if got := shortNames(test.repos); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("shortNames(%q) = %v, want %v", test.repos, got, test.want)
+		}
+	}
+}
+
+func TestBasePrefixesAreDistinct(t *testing.T) {
+	defer func(saved string) { prefixStyle = saved }(prefixStyle)
+	prefixStyle = "base"
+
+	format, err := parsePrefixFormat(defaultPrefixFormat)
+	if err != nil {
+		t.Fatal(err)
+	}
+	format.names = shortNames([]string{"frontend/lib", "backend/lib"})
+
+	first := format.render(Command{Repo: "frontend/lib"})
+	second := format.render(Command{Repo: "backend/lib"})
+	if first != "[frontend/lib] " || second != "[backend/lib] " {
+		t.Errorf("prefixes are %q and %q", first, second)
+	}
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"fmt"
+	"os"
+)
+
+// progress reports how many commands have completed as results arrive
+type progress struct {
+	file    *os.File
+	inPlace bool
+	total   int
+	printed bool
+}
+
+// newProgress creates a progress reporter for total commands that writes to
+// file, updating a single line when file is a terminal
+func newProgress(file *os.File, total int) *progress {
+	return &progress{file: file, inPlace: isTerminal(file), total: total}
+}
+
+// update prints the progress line for counts
+func (p *progress) update(counts statCounts) {
+	p.printed = true
+	line := fmt.Sprintf("[%d/%d] done, %d failed", counts.total(), p.total, counts.failed)
+	if p.inPlace {
+		fmt.Fprintf(p.file, "\r\x1b[K%s", line)
+	} else {
+		fmt.Fprintln(p.file, line)
+	}
+}
+
+// finish ends the in-place progress line
+func (p *progress) finish() {
+	if p.inPlace && p.printed {
+		fmt.Fprintln(p.file)
+	}
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+)
+
+// repeatRuns runs commands with runBatch n times, each under a
+// "=== run i/n ===" header, then lists how often each repository failed.
+// With -fail-fast the first run with a failure is the last. It returns the
+// exit code of the last run that did not succeed, if any.
+func repeatRuns(ctx context.Context, interrupted context.Context, commands [][]Command, skipped []CommandResult, report io.Writer, n int) int {
+	failedRuns := map[string][]int{}
+	code := exitSuccess
+	run := 1
+	for ; run <= n; run++ {
+		fmt.Fprintf(report, "=== run %d/%d ===\n", run, n)
+		runCode, results := runBatch(ctx, interrupted, commands, skipped, report)
+		for _, result := range results {
+			if !result.Success {
+				failedRuns[result.Command.Repo] = append(failedRuns[result.Command.Repo], run)
+			}
+		}
+		if runCode != exitSuccess {
+			code = runCode
+		}
+		if ctx.Err() != nil || (failFast && runCode != exitSuccess) {
+			break
+		}
+	}
+	if run > n {
+		run = n
+	}
+
+	printRepeatFailures(report, failedRuns, run)
+	if interrupted.Err() != nil {
+		return exitInterrupted
+	}
+	return code
+}
+
+// printRepeatFailures writes, for each repository that failed at least
+// once, how many of the runs it failed and which ones
+func printRepeatFailures(w io.Writer, failedRuns map[string][]int, runs int) {
+	if len(failedRuns) == 0 {
+		fmt.Fprintf(w, "no failures in %d run(s)\n", runs)
+		return
+	}
+
+	repos := []string{}
+	for repo := range failedRuns {
+		repos = append(repos, repo)
+	}
+	sort.Strings(repos)
+
+	fmt.Fprintf(w, "failures in %d run(s):\n", runs)
+	table := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	for _, repo := range repos {
+		numbers := []string{}
+		for _, run := range failedRuns[repo] {
+			numbers = append(numbers, strconv.Itoa(run))
+		}
+		fmt.Fprintf(table, "  %s\t%d/%d\t(runs %s)\n", repo, len(failedRuns[repo]), runs, strings.Join(numbers, ", "))
+	}
+	table.Flush()
+}
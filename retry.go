@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+	"math"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/saquib.mian/pgit/runner"
+)
+
+// jitter randomizes exponential backoff delays, seeded by -seed when it is
+// set so that runs can be reproduced
+var jitter = struct {
+	sync.Mutex
+	rand *rand.Rand
+}{}
+
+// jitterSource returns the random source for retry jitter, creating it on
+// first use
+func jitterSource() *rand.Rand {
+	if jitter.rand == nil {
+		source := seed
+		if source == 0 {
+			source = time.Now().UnixNano()
+		}
+		jitter.rand = rand.New(rand.NewSource(source))
+	}
+	return jitter.rand
+}
+
+// retryDelay is how long to wait before the given retry, counting from 1.
+// Delays are capped at -retry-max-delay, 0 meaning no cap. With
+// -retry-backoff exp the delay doubles each time, and then up to half of it
+// is taken off again as jitter, so that repositories which failed together
+// do not all retry at once, even once they reach the cap.
+func retryDelay(attempt int) time.Duration {
+	delay := retryBase
+	if retryBackoff == "exp" {
+		for i := 1; i < attempt; i++ {
+			if (retryMaxDelay > 0 && delay >= retryMaxDelay) || delay > math.MaxInt64/2 {
+				break
+			}
+			delay *= 2
+		}
+	}
+	if retryMaxDelay > 0 && delay > retryMaxDelay {
+		delay = retryMaxDelay
+	}
+	if retryBackoff == "exp" && delay > 0 {
+		jitter.Lock()
+		delay -= time.Duration(jitterSource().Int63n(int64(delay)/2 + 1))
+		jitter.Unlock()
+	}
+	return delay
+}
+
+// shouldRetry reports whether a failed result is worth another attempt.
+// Commands killed because the run was stopped are not.
+func shouldRetry(ctx context.Context, result CommandResult) bool {
+	_, cancelled := result.Error.(*runner.CancelledError)
+	return !result.Success && !cancelled && ctx.Err() == nil
+}
+
+// sleepContext waits for d, returning false if ctx is done first
+func sleepContext(ctx context.Context, d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
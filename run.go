@@ -0,0 +1,357 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/saquib.mian/pgit/logwriter"
+	"github.com/saquib.mian/pgit/runner"
+)
+
+// wholeSequence is the phase of a sequence run in one go rather than a step
+// at a time by runPhases
+const wholeSequence = -1
+
+// runSteps runs each command of a repository's sequence in order, stopping at
+// the first failure. The result describes the last step that ran. phase is
+// wholeSequence, or the step steps holds when runPhases runs one step at a
+// time. After the first phase the repository is taken to have started
+// already: it is not checked against -dirty and the like again, no start is
+// reported, and its log file is appended to. A phase's result is left for
+// runPhases to merge and finish.
+func runSteps(ctx context.Context, steps []Command, phase int) CommandResult {
+	if phase <= 0 {
+		events.started(steps[0])
+		diag.event("repo-start", steps[0].Repo, map[string]interface{}{"index": steps[0].Index})
+	}
+	result := runRepo(ctx, steps, phase)
+	if phase != wholeSequence {
+		return result
+	}
+	return finishRepo(result)
+}
+
+// finishRepo completes the result of a repository whose sequence is done,
+// printing its output now with -block or -quiet-success
+func finishRepo(result CommandResult) CommandResult {
+	if showBranch {
+		result.Branch = displayBranch(repoDir(result.Command.Repo))
+	}
+	if quietSuccess && result.Success {
+		// output of successful repos is discarded
+		result.display = nil
+	}
+	if (blockOutput || quietSuccess) && !orderedOutput && !groupIdentical && result.display != nil {
+		writeBlock(os.Stdout, os.Stderr, result)
+	}
+	return result
+}
+
+func runRepo(ctx context.Context, steps []Command, phase int) CommandResult {
+	first := steps[0]
+
+	if phase <= 0 {
+		if result, ok := checkRepo(ctx, first); !ok {
+			return result
+		}
+	}
+
+	if dryRun {
+		if !structuredOutput() {
+			for _, cmd := range steps {
+				fmt.Println(cmd.String())
+			}
+		}
+		return CommandResult{Success: true, Command: steps[len(steps)-1], Step: len(steps) - 1}
+	}
+
+	if structuredOutput() {
+		// buffer the whole output so results can be printed atomically
+		var stdoutBuf, stderrBuf bytes.Buffer
+		result := runSequence(ctx, &stdoutBuf, &stderrBuf, steps, nil)
+		if phase == wholeSequence {
+			result = checkOutput(result, stdoutBuf.String())
+		}
+		result.Stdout = stdoutBuf.String()
+		result.Stderr = stderrBuf.String()
+		tail := &tailBuffer{n: failureTailLines}
+		io.WriteString(tail, result.Stderr)
+		result.stderrTail = tail.tail()
+		return result
+	}
+
+	// cancelled to kill the repository's commands with -kill-on-truncate
+	ctx, cancelRepo := context.WithCancel(ctx)
+	defer cancelRepo()
+
+	var stdoutTarget, stderrTarget io.Writer = os.Stdout, os.Stderr
+	var display *bufferedOutput
+	if bufferDisplay() {
+		display = &bufferedOutput{}
+		stdoutTarget, stderrTarget = &display.stdout, &display.stderr
+	}
+
+	// the log file gets each stream through its own writer, since the
+	// child's stdout and stderr are copied concurrently
+	var logStdout, logStderr io.Writer
+	var logWriters []*logwriter.LogWriter
+	if logDir != "" {
+		f, err := createRepoLog(logDir, first.Repo, phase > 0)
+		if err != nil {
+			return CommandResult{Error: fmt.Errorf("could not create log file: %s", err.Error()), Command: first, ExitCode: -1}
+		}
+		defer f.Close()
+		logStdout, logStderr = f, f
+		if logPrefixed {
+			// log files never get color codes. The writers share a logger,
+			// which writes each line whole.
+			logger := log.New(f, prefixTemplate.render(first), 0)
+			logWriters = []*logwriter.LogWriter{logwriter.NewLogWriter(logger), logwriter.NewLogWriter(logger)}
+			logStdout, logStderr = logWriters[0], logWriters[1]
+		}
+		if !teeOutput {
+			stdoutTarget, stderrTarget = ioutil.Discard, ioutil.Discard
+		}
+	}
+	flush := func(writers ...*logwriter.LogWriter) {
+		for _, w := range append(writers, logWriters...) {
+			w.Flush()
+		}
+	}
+
+	prefix := repoPrefix(first)
+	stdout := log.New(stdoutTarget, prefix, 0)
+	stderr := stderrLogger(stderrTarget, first.Repo, prefix)
+	// with -no-prefix the "-->" lines are left off the terminal, so stdout
+	// carries nothing but the commands' output
+	headers := stdout
+	if noPrefix {
+		headers = log.New(ioutil.Discard, "", 0)
+	}
+
+	// note writes one of pgit's own lines, which log files get unprefixed
+	// unless -log-prefix is set. With -log-format json the terminal gets
+	// diag events instead.
+	note := func(logger *log.Logger, format string, args ...interface{}) {
+		if !jsonLogs() {
+			logger.Printf(format, args...)
+		}
+		if logStdout != nil {
+			fmt.Fprintf(logStdout, format, args...)
+		}
+	}
+
+	stdoutWriter := logwriter.NewLogWriter(stdout)
+	stderrWriter := logwriter.NewLogWriter(stderr)
+	for _, w := range []*logwriter.LogWriter{stdoutWriter, stderrWriter} {
+		w.MaxLines, w.MaxBytes = maxLines, maxBytes
+		if killOnTruncate {
+			w.OnTruncate = cancelRepo
+		}
+	}
+
+	var childStdout, childStderr io.Writer = stdoutWriter, stderrWriter
+	if noPrefix {
+		// output goes straight through, a byte at a time as it is written
+		childStdout, childStderr = stdoutTarget, stderrTarget
+		if jsonLogs() {
+			// stderr is still made into output events
+			childStderr = stderrWriter
+		}
+	}
+	if logStdout != nil {
+		childStdout = io.MultiWriter(childStdout, logStdout)
+		childStderr = io.MultiWriter(childStderr, logStderr)
+	}
+
+	stderrTail := &tailBuffer{n: failureTailLines}
+	childStderr = io.MultiWriter(childStderr, stderrTail)
+
+	// raw output is kept for features that inspect it after the run
+	var rawStdout, rawStderr bytes.Buffer
+	if captureOutput() {
+		childStdout = io.MultiWriter(childStdout, &rawStdout)
+		childStderr = io.MultiWriter(childStderr, &rawStderr)
+	}
+
+	result := runSequence(ctx, childStdout, childStderr, steps, func(cmd Command, attempt int) {
+		// keep each step's output ahead of the next step's header
+		flush(stdoutWriter, stderrWriter)
+		diag.event("step", cmd.Repo, map[string]interface{}{"command": cmd.String(), "attempt": attempt})
+		if attempt > 1 {
+			note(stderr, "--> retrying %s (attempt %d of %d)\n", cmd.String(), attempt, retryCount+1)
+		} else if verbosity >= normalLevel && showBranch {
+			note(headers, "--> %s (%s)\n", cmd.String(), displayBranch(repoDir(cmd.Repo)))
+		} else if verbosity >= normalLevel {
+			note(headers, "--> %s\n", cmd.String())
+		}
+	})
+	// output written before a timeout or failure is kept, even without a
+	// final newline
+	flush(stdoutWriter, stderrWriter)
+	if phase == wholeSequence {
+		result = checkOutput(result, rawStdout.String())
+	}
+
+	if !result.Success {
+		note(stderr, "error: %s\n", result.Error.Error())
+	}
+	if verbosity >= verboseLevel {
+		note(headers, "<-- finished in %s\n", result.Duration.Round(time.Millisecond))
+	}
+
+	result.Stdout = rawStdout.String()
+	result.Stderr = rawStderr.String()
+	result.display = display
+	result.stderrTail = stderrTail.tail()
+	return result
+}
+
+// checkRepo checks that the working directory of first exists and applies
+// -skip-locked, -safe, -dirty, -only-if and -require to its repository,
+// reporting false with the result to use when it should not run
+func checkRepo(ctx context.Context, first Command) (CommandResult, bool) {
+	if info, err := os.Stat(first.WorkingDir); err != nil || !info.IsDir() {
+		return CommandResult{Error: fmt.Errorf("working directory %s does not exist", first.WorkingDir), Command: first, ExitCode: -1}, false
+	}
+
+	if skipLocked && isLocked(repoDir(first.Repo)) {
+		return CommandResult{Success: true, Skipped: "locked", Command: first}, false
+	}
+
+	if safeMode {
+		if state := unsafeState(repoDir(first.Repo)); state != "" && unsafeOK {
+			warnf("%s is in an unsafe state (%s)", first.Repo, state)
+		} else if state != "" {
+			return CommandResult{Success: true, Skipped: "unsafe state", Command: first}, false
+		}
+	}
+
+	if onlyDirty {
+		dirty, err := isDirty(repoDir(first.Repo))
+		if err != nil {
+			return CommandResult{Error: fmt.Errorf("could not check status: %s", err.Error()), Command: first, ExitCode: -1}, false
+		}
+		if !dirty {
+			return CommandResult{Success: true, Skipped: "clean", Command: first}, false
+		}
+	}
+
+	if dryRun {
+		// predicates are commands too, so a dry run does not run them
+		return CommandResult{}, true
+	}
+
+	if onlyIf != "" {
+		if ok, _ := runPredicate(ctx, first, onlyIf); !ok {
+			return CommandResult{Success: true, Skipped: "not applicable", Command: first}, false
+		}
+	}
+
+	if requireCmd != "" {
+		if ok, output := runPredicate(ctx, first, requireCmd); !ok {
+			result := CommandResult{Success: true, Skipped: "require failed", Command: first}
+			if structuredOutput() {
+				result.Stderr = output
+			} else {
+				// written at once so it is not interleaved with other repos
+				var b bytes.Buffer
+				w := logwriter.NewLogWriter(stderrLogger(&b, first.Repo, repoPrefix(first)))
+				io.WriteString(w, output)
+				w.Flush()
+				os.Stderr.Write(b.Bytes())
+			}
+			return result, false
+		}
+	}
+	return CommandResult{}, true
+}
+
+// runSequence runs steps in order with the same output streams, calling
+// before (if set) ahead of each attempt, which counts from 1. A failed step
+// is retried up to -retry times. Durations and retries are summed across
+// steps.
+func runSequence(ctx context.Context, stdout io.Writer, stderr io.Writer, steps []Command, before func(cmd Command, attempt int)) CommandResult {
+	var result CommandResult
+	var total time.Duration
+	retries := 0
+	for i, cmd := range steps {
+		for attempt := 1; ; attempt++ {
+			if attempt > 1 && !sleepContext(ctx, retryDelay(attempt-1)) {
+				break
+			}
+			if before != nil {
+				before(cmd, attempt)
+			}
+			result = runThrottled(ctx, stdout, stderr, cmd)
+			total += result.Duration
+			if attempt > retryCount || !shouldRetry(ctx, result) {
+				break
+			}
+			retries++
+		}
+		result.Step = i
+		if !result.Success {
+			break
+		}
+	}
+	result.Duration = total
+	result.retries = retries
+	return result
+}
+
+// runThrottled runs cmd once the per-host limit allows it. Repositories
+// without a remote share a single default bucket.
+func runThrottled(ctx context.Context, stdout io.Writer, stderr io.Writer, cmd Command) CommandResult {
+	if hosts == nil {
+		return runCommand(ctx, stdout, stderr, cmd, cmd.Timeout)
+	}
+
+	remote, _ := repoRemote(repoDir(cmd.Repo))
+	release, err := hosts.acquire(ctx, remoteHost(remote))
+	if err != nil {
+		return CommandResult{Error: &runner.CancelledError{Command: cmd}, Command: cmd, ExitCode: -1}
+	}
+	defer release()
+	return runCommand(ctx, stdout, stderr, cmd, cmd.Timeout)
+}
+
+// repoPrefix is the prefix written before each line of a repository's
+// output, rendered from -prefix-format
+func repoPrefix(cmd Command) string {
+	prefix := prefixTemplate.render(cmd)
+	label := strings.TrimRight(prefix, " ")
+	if useColor && label != "" {
+		// trailing spaces are left uncolored
+		prefix = colorize(cmd.Repo, label) + prefix[len(label):]
+	}
+	return prefix
+}
+
+// runCommand runs command with the given timeout through runner.Exec,
+// applying -combined and -process-group
+func runCommand(ctx context.Context, stdout io.Writer, stderr io.Writer, command Command, timeout time.Duration) CommandResult {
+	exec := command
+	exec.Timeout = timeout
+	exec.ProcessGroup = processGroup
+	if combinedOutput {
+		// a single writer makes the child share one pipe for both streams
+		stderr = stdout
+	}
+
+	result := runner.Exec(ctx, stdout, stderr, exec)
+	return CommandResult{
+		Success:  result.Success,
+		Error:    result.Error,
+		Command:  command,
+		ExitCode: result.ExitCode,
+		Duration: result.Duration,
+	}
+}
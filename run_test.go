@@ -0,0 +1,38 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"log"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/saquib.mian/pgit/logwriter"
+	"github.com/saquib.mian/pgit/runner"
+)
+
+func TestTimeoutKeepsPartialOutput(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("needs sh")
+	}
+	var out bytes.Buffer
+	w := logwriter.NewLogWriter(log.New(&out, "[repo] ", 0))
+	cmd := Command{
+		Repo:       "repo",
+		WorkingDir: t.TempDir(),
+		Command:    "sh",
+		Args:       []string{"-c", "echo started; printf partial; exec sleep 5"},
+	}
+
+	result := runCommand(context.Background(), w, w, cmd, 200*time.Millisecond)
+	w.Flush()
+
+	if !errors.As(result.Error, new(*runner.TimeoutError)) {
+		t.Fatalf("error = %v, want a timeout", result.Error)
+	}
+	if want := "[repo] started\n[repo] partial\n"; out.String() != want {
+		t.Errorf("output = %q, want %q", out.String(), want)
+	}
+}
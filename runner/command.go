@@ -0,0 +1,44 @@
+// Package runner runs commands in many git repositories at once. It is the
+// core of pgit, which adds output prefixing, filtering and reporting on top.
+package runner
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Command is a program to run in a repository
+type Command struct {
+	Repo string
+	// Index is the repo's position in the run, counting from 1
+	Index      int
+	WorkingDir string
+	Command    string
+	Args       []string
+	Env        []string
+	// Shell runs Command as a script through the platform's shell
+	Shell bool
+	// Timeout is how long the command may run, or 0 for no limit
+	Timeout time.Duration
+	// ProcessGroup runs the command in its own process group, so signals
+	// from the terminal reach only the calling program (not on Windows)
+	ProcessGroup bool
+}
+
+func (c *Command) String() string {
+	return fmt.Sprintf("'%s %s' in '%s'", c.Command, strings.Join(c.Args, " "), c.WorkingDir)
+}
+
+// Result is the outcome of running a Command, or the last command of a
+// sequence that ran. Step is the index of that command within the sequence.
+type Result struct {
+	Success  bool
+	Error    error
+	Command  Command
+	Step     int
+	ExitCode int
+	Stdout   string
+	Stderr   string
+	Duration time.Duration
+}
@@ -0,0 +1,113 @@
+package runner
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// DiscoverOptions controls which directories Discover returns
+type DiscoverOptions struct {
+	// Depth is how many directory levels to search; 0 is the same as 1
+	Depth int
+	// AllDirectories counts every directory as a repository
+	AllDirectories bool
+	// IncludeBare includes bare repositories, which are left out by default
+	IncludeBare bool
+	// Git is the git executable to run, "git" if empty
+	Git string
+}
+
+// Discover walks root and returns the paths, relative to root, of every
+// directory containing a .git entry. Repositories are not descended into.
+func Discover(root string, opts DiscoverOptions) ([]string, error) {
+	dirs, err := os.ReadDir(root)
+	if err != nil {
+		return nil, fmt.Errorf("could not read %s: %s", root, err.Error())
+	}
+
+	repos := []string{}
+	for _, dir := range dirs {
+		if !dir.IsDir() || dir.Name() == ".git" {
+			// not a directory
+			continue
+		}
+		if IsBareRepo(opts.Git, filepath.Join(root, dir.Name())) {
+			if opts.IncludeBare {
+				repos = append(repos, dir.Name())
+			}
+			continue
+		}
+		if opts.AllDirectories {
+			repos = append(repos, dir.Name())
+			continue
+		}
+		if IsRepo(opts.Git, filepath.Join(root, dir.Name())) {
+			repos = append(repos, dir.Name())
+			continue
+		}
+		if opts.Depth <= 1 {
+			// too deep
+			continue
+		}
+
+		nested := opts
+		nested.Depth--
+		found, err := Discover(filepath.Join(root, dir.Name()), nested)
+		if err != nil {
+			// unreadable subdirectories are skipped
+			continue
+		}
+		for _, repo := range found {
+			repos = append(repos, filepath.Join(dir.Name(), repo))
+		}
+	}
+
+	return repos, nil
+}
+
+// IsRepo reports whether dir is the root of a git repository. Worktrees and
+// submodules have a .git file pointing at the real git directory, which git
+// is asked to confirm.
+func IsRepo(git string, dir string) bool {
+	info, err := os.Stat(filepath.Join(dir, ".git"))
+	if err != nil {
+		return false
+	}
+	if info.IsDir() {
+		return true
+	}
+
+	contents, err := os.ReadFile(filepath.Join(dir, ".git"))
+	if err != nil || !strings.HasPrefix(string(contents), "gitdir:") {
+		return false
+	}
+	inside, err := GitOutput(git, dir, "rev-parse", "--is-inside-work-tree")
+	return err == nil && inside == "true"
+}
+
+// IsBareRepo reports whether dir is a bare repository. The layout is checked
+// before asking git, so most directories cost no git process.
+func IsBareRepo(git string, dir string) bool {
+	for _, name := range []string{"HEAD", "objects", "refs"} {
+		if _, err := os.Stat(filepath.Join(dir, name)); err != nil {
+			return false
+		}
+	}
+	bare, err := GitOutput(git, dir, "rev-parse", "--is-bare-repository")
+	return err == nil && bare == "true"
+}
+
+// GitOutput runs git with args in dir and returns its trimmed stdout. An
+// empty git runs "git" from the PATH.
+func GitOutput(git string, dir string, args ...string) (string, error) {
+	if git == "" {
+		git = "git"
+	}
+	process := exec.Command(git, args...)
+	process.Dir = dir
+	out, err := process.Output()
+	return strings.TrimSpace(string(out)), err
+}
@@ -0,0 +1,106 @@
+package runner
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// git runs git in dir, failing the test if it does not succeed
+func git(t *testing.T, dir string, args ...string) {
+	t.Helper()
+	process := exec.Command("git", args...)
+	process.Dir = dir
+	process.Env = append(os.Environ(),
+		"GIT_AUTHOR_NAME=test", "GIT_AUTHOR_EMAIL=test@example.com",
+		"GIT_COMMITTER_NAME=test", "GIT_COMMITTER_EMAIL=test@example.com",
+		"GIT_CONFIG_NOSYSTEM=1", "HOME="+dir)
+	if out, err := process.CombinedOutput(); err != nil {
+		t.Fatalf("git %q: %s\n%s", args, err, out)
+	}
+}
+
+// newRepo creates a repository with one commit at dir
+func newRepo(t *testing.T, dir string) {
+	t.Helper()
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	git(t, dir, "init", "-q")
+	git(t, dir, "commit", "-q", "--allow-empty", "-m", "init")
+}
+
+func TestDiscoverMissingRoot(t *testing.T) {
+	root := filepath.Join(t.TempDir(), "missing")
+	if _, err := Discover(root, DiscoverOptions{}); err == nil {
+		t.Errorf("Discover(%q) returned no error", root)
+	}
+}
+
+func TestIsRepoWorktree(t *testing.T) {
+	root := t.TempDir()
+	newRepo(t, filepath.Join(root, "main"))
+	git(t, filepath.Join(root, "main"), "worktree", "add", "-q", filepath.Join(root, "linked"))
+
+	if !IsRepo("", filepath.Join(root, "linked")) {
+		t.Error("a linked worktree, whose .git is a file, is not a repo")
+	}
+
+	// .git files that do not point at a real git directory
+	for name, contents := range map[string]string{
+		"dangling": "gitdir: " + filepath.Join(root, "missing"),
+		"junk":     "not a pointer",
+	} {
+		dir := filepath.Join(root, name)
+		os.Mkdir(dir, 0755)
+		if err := os.WriteFile(filepath.Join(dir, ".git"), []byte(contents), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if IsRepo("", dir) {
+			t.Errorf("%s with .git %q is a repo", name, contents)
+		}
+	}
+
+	repos, err := Discover(root, DiscoverOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"linked", "main"}; !reflect.DeepEqual(repos, want) {
+		t.Errorf("Discover = %q, want %q", repos, want)
+	}
+}
+
+func TestDiscoverBare(t *testing.T) {
+	root := t.TempDir()
+	newRepo(t, filepath.Join(root, "mytool.git"))
+	os.Mkdir(filepath.Join(root, "mirror.git"), 0755)
+	git(t, filepath.Join(root, "mirror.git"), "init", "-q", "--bare")
+
+	if !IsBareRepo("", filepath.Join(root, "mirror.git")) {
+		t.Error("a bare repository is not bare")
+	}
+	if IsBareRepo("", filepath.Join(root, "mytool.git")) {
+		t.Error("a checkout named mytool.git is bare")
+	}
+
+	repos, err := Discover(root, DiscoverOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"mytool.git"}; !reflect.DeepEqual(repos, want) {
+		t.Errorf("Discover = %q, want %q", repos, want)
+	}
+
+	repos, err = Discover(root, DiscoverOptions{IncludeBare: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"mirror.git", "mytool.git"}; !reflect.DeepEqual(repos, want) {
+		t.Errorf("Discover with IncludeBare = %q, want %q", repos, want)
+	}
+}
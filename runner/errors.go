@@ -0,0 +1,45 @@
+package runner
+
+// StartError is returned when a command could not be started at all
+type StartError struct {
+	Command Command
+	Err     error
+}
+
+func (e *StartError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *StartError) Unwrap() error {
+	return e.Err
+}
+
+// TimeoutError is returned when a command was killed for running longer
+// than its timeout
+type TimeoutError struct {
+	Command Command
+}
+
+func (e *TimeoutError) Error() string {
+	return "process timed out: " + e.Command.String()
+}
+
+// CancelledError is returned when a command was killed because the run was
+// stopped
+type CancelledError struct {
+	Command Command
+}
+
+func (e *CancelledError) Error() string {
+	return "process cancelled: " + e.Command.String()
+}
+
+// ExitError is returned when a command ran but exited with a non-zero code
+type ExitError struct {
+	Command Command
+	Code    int
+}
+
+func (e *ExitError) Error() string {
+	return "exited with non-zero exit code"
+}
@@ -0,0 +1,86 @@
+package runner
+
+import (
+	"context"
+	"io"
+	"os"
+	"os/exec"
+	"runtime"
+	"syscall"
+	"time"
+)
+
+// ShellCommand returns the program and arguments that run script through
+// the platform's shell
+func ShellCommand(script string) (string, []string) {
+	if runtime.GOOS == "windows" {
+		return "cmd", []string{"/c", script}
+	}
+	return "sh", []string{"-c", script}
+}
+
+// Exec runs command, writing its output to stdout and stderr, and waits for
+// it to finish. Passing the same writer for both makes the command share one
+// pipe for the two streams, keeping them in the order they were written.
+func Exec(ctx context.Context, stdout io.Writer, stderr io.Writer, command Command) Result {
+	parent := ctx
+	if command.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, command.Timeout)
+		defer cancel()
+	}
+
+	program, args := command.Command, command.Args
+	if command.Shell {
+		program, args = ShellCommand(command.Command)
+	}
+	process := exec.CommandContext(ctx, program, args...)
+	process.Stdout = stdout
+	process.Stderr = stderr
+	if len(command.Env) > 0 {
+		process.Env = append(os.Environ(), command.Env...)
+	}
+	if command.WorkingDir != "" {
+		process.Dir = command.WorkingDir
+	}
+	if command.ProcessGroup {
+		setProcessGroup(process)
+	}
+
+	start := time.Now()
+	if err := process.Start(); err != nil {
+		return Result{Error: &StartError{Command: command, Err: err}, Command: command, ExitCode: -1}
+	}
+	untrack := trackProcess(process.Process, command.ProcessGroup)
+	defer untrack()
+
+	if err := process.Wait(); err != nil {
+		exitCode := -1
+		exitErr, exited := err.(*exec.ExitError)
+		if exited {
+			exitCode = exitStatus(exitErr)
+		}
+
+		// only the command's own timeout makes it a TimeoutError; ctx
+		// ending, whether cancelled or past a deadline, stops it from outside
+		if parent.Err() != nil {
+			err = &CancelledError{Command: command}
+		} else if ctx.Err() == context.DeadlineExceeded {
+			err = &TimeoutError{Command: command}
+		} else if exited {
+			err = &ExitError{Command: command, Code: exitCode}
+		}
+		return Result{Error: err, Command: command, ExitCode: exitCode, Duration: time.Since(start)}
+	}
+
+	return Result{Success: true, Command: command, Duration: time.Since(start)}
+}
+
+// exitStatus returns the exit code of a finished process, following the shell
+// convention of 128+signal for processes killed by a signal
+func exitStatus(err *exec.ExitError) int {
+	if status, ok := err.Sys().(syscall.WaitStatus); ok && status.Signaled() {
+		return 128 + int(status.Signal())
+	}
+	return err.ExitCode()
+}
@@ -0,0 +1,87 @@
+package runner
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Options describes a run. Each entry of Commands is the sequence of
+// commands for one repository, run in order until one fails.
+type Options struct {
+	Commands [][]Command
+	// Concurrency is how many sequences run at a time, or 0 for all of them
+	Concurrency int
+	// RunSequence, if set, runs each sequence in place of RunSteps, for
+	// callers that handle output or retries themselves. It is called from
+	// several goroutines at once.
+	RunSequence func(ctx context.Context, steps []Command) Result
+}
+
+// Run runs every sequence in opts and returns their results in the same
+// order. Once ctx is cancelled running commands are killed and sequences
+// that have not started fail with a CancelledError.
+func Run(ctx context.Context, opts Options) ([]Result, error) {
+	for i, steps := range opts.Commands {
+		if len(steps) == 0 {
+			return nil, fmt.Errorf("sequence %d has no commands", i)
+		}
+	}
+
+	run := opts.RunSequence
+	if run == nil {
+		run = RunSteps
+	}
+	limit := opts.Concurrency
+	if limit <= 0 || limit > len(opts.Commands) {
+		limit = len(opts.Commands)
+	}
+
+	results := make([]Result, len(opts.Commands))
+	input := make(chan int)
+	var wg sync.WaitGroup
+	for i := 0; i < limit; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range input {
+				steps := opts.Commands[i]
+				if ctx.Err() != nil {
+					// cancelled before this sequence could start
+					results[i] = Result{Error: &CancelledError{Command: steps[0]}, Command: steps[0], ExitCode: -1}
+					continue
+				}
+				results[i] = run(ctx, steps)
+			}
+		}()
+	}
+	for i := range opts.Commands {
+		input <- i
+	}
+	close(input)
+	wg.Wait()
+
+	return results, nil
+}
+
+// RunSteps runs steps in order, stopping at the first failure, with their
+// output in Stdout and Stderr. Durations are summed across steps.
+func RunSteps(ctx context.Context, steps []Command) Result {
+	var stdout, stderr bytes.Buffer
+	var result Result
+	var total time.Duration
+	for i, cmd := range steps {
+		result = Exec(ctx, &stdout, &stderr, cmd)
+		result.Step = i
+		total += result.Duration
+		if !result.Success {
+			break
+		}
+	}
+	result.Duration = total
+	result.Stdout = stdout.String()
+	result.Stderr = stderr.String()
+	return result
+}
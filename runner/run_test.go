@@ -0,0 +1,109 @@
+package runner
+
+import (
+	"context"
+	"errors"
+	"runtime"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+// sh returns a command running script with sh in dir
+func sh(repo string, dir string, script string) Command {
+	return Command{Repo: repo, WorkingDir: dir, Command: "sh", Args: []string{"-c", script}}
+}
+
+func TestRunStopsAtFirstFailure(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("needs sh")
+	}
+	dir := t.TempDir()
+	results, err := Run(context.Background(), Options{Commands: [][]Command{
+		{sh("ok", dir, "echo one"), sh("ok", dir, "echo two >&2")},
+		{sh("fails", dir, "echo one"), sh("fails", dir, "exit 3"), sh("fails", dir, "echo never")},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// results come back in the order of the sequences
+	ok, fails := results[0], results[1]
+	if !ok.Success || ok.Command.Repo != "ok" || ok.Step != 1 || ok.Stdout != "one\n" || ok.Stderr != "two\n" {
+		t.Errorf("ok = %+v, want success at step 1 with both steps' output", ok)
+	}
+	if fails.Success || fails.Command.Repo != "fails" || fails.Step != 1 || fails.ExitCode != 3 || fails.Stdout != "one\n" {
+		t.Errorf("fails = %+v, want exit code 3 at step 1", fails)
+	}
+	if !errors.As(fails.Error, new(*ExitError)) {
+		t.Errorf("fails.Error = %v, want an ExitError", fails.Error)
+	}
+}
+
+func TestRunConcurrency(t *testing.T) {
+	const limit = 3
+	commands := make([][]Command, 10)
+	for i := range commands {
+		commands[i] = []Command{{Repo: strconv.Itoa(i)}}
+	}
+
+	var mu sync.Mutex
+	running, peak := 0, 0
+	results, err := Run(context.Background(), Options{
+		Commands:    commands,
+		Concurrency: limit,
+		RunSequence: func(ctx context.Context, steps []Command) Result {
+			mu.Lock()
+			running++
+			if running > peak {
+				peak = running
+			}
+			mu.Unlock()
+			time.Sleep(10 * time.Millisecond)
+			mu.Lock()
+			running--
+			mu.Unlock()
+			return Result{Success: true, Command: steps[0]}
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if peak > limit {
+		t.Errorf("%d sequences ran at once, want at most %d", peak, limit)
+	}
+	for i, result := range results {
+		if result.Command.Repo != strconv.Itoa(i) || !result.Success {
+			t.Errorf("result %d = %+v, want success for repo %d", i, result, i)
+		}
+	}
+}
+
+func TestRunCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	called := false
+	results, err := Run(ctx, Options{
+		Commands: [][]Command{{{Repo: "repo"}}},
+		RunSequence: func(ctx context.Context, steps []Command) Result {
+			called = true
+			return Result{Success: true}
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if called {
+		t.Error("a sequence started after the run was cancelled")
+	}
+	if !errors.As(results[0].Error, new(*CancelledError)) {
+		t.Errorf("error = %v, want a CancelledError", results[0].Error)
+	}
+}
+
+func TestRunEmptySequence(t *testing.T) {
+	if _, err := Run(context.Background(), Options{Commands: [][]Command{{}}}); err == nil {
+		t.Error("Run accepted a sequence with no commands")
+	}
+}
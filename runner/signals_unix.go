@@ -0,0 +1,70 @@
+//go:build !windows
+// +build !windows
+
+package runner
+
+import (
+	"os"
+	"os/exec"
+	"os/signal"
+	"sync"
+	"syscall"
+)
+
+// running holds the commands that have started and not yet finished, so job
+// control signals can be passed on to them. Each pid maps to whether it
+// leads its own process group.
+var running = struct {
+	sync.Mutex
+	pids map[int]bool
+}{pids: map[int]bool{}}
+
+func setProcessGroup(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+}
+
+// trackProcess records p as running until the returned func is called
+func trackProcess(p *os.Process, group bool) func() {
+	running.Lock()
+	running.pids[p.Pid] = group
+	running.Unlock()
+	return func() {
+		running.Lock()
+		delete(running.pids, p.Pid)
+		running.Unlock()
+	}
+}
+
+// signalRunning sends sig to every running command, or to its whole process
+// group if it has one
+func signalRunning(sig syscall.Signal) {
+	running.Lock()
+	defer running.Unlock()
+	for pid, group := range running.pids {
+		if group {
+			pid = -pid
+		}
+		syscall.Kill(pid, sig)
+	}
+}
+
+// ForwardJobControl passes SIGTSTP and SIGCONT on to running commands, so
+// that suspending the program with Ctrl+Z suspends what it is running too,
+// and resuming it with fg or bg resumes them
+func ForwardJobControl() {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGTSTP, syscall.SIGCONT)
+	go func() {
+		for sig := range signals {
+			if sig == syscall.SIGCONT {
+				signalRunning(syscall.SIGCONT)
+				continue
+			}
+			// children in another process group never see the terminal's
+			// SIGTSTP, and the program has to stop itself now that it
+			// handles it
+			signalRunning(syscall.SIGTSTP)
+			syscall.Kill(os.Getpid(), syscall.SIGSTOP)
+		}
+	}()
+}
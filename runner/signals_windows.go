@@ -0,0 +1,17 @@
+package runner
+
+import (
+	"os"
+	"os/exec"
+)
+
+// Windows has no job control signals or process groups to set up
+
+func setProcessGroup(cmd *exec.Cmd) {}
+
+func trackProcess(p *os.Process, group bool) func() {
+	return func() {}
+}
+
+// ForwardJobControl does nothing on Windows
+func ForwardJobControl() {}
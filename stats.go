@@ -0,0 +1,64 @@
+package main
+
+import (
+	"fmt"
+	"sync/atomic"
+	"time"
+)
+
+// runStats counts results by outcome. The counters are updated atomically,
+// so workers and the main loop can share one runStats.
+type runStats struct {
+	succeeded int64
+	failed    int64
+	skipped   int64
+	retried   int64
+}
+
+// statCounts is a snapshot of a runStats
+type statCounts struct {
+	succeeded int
+	failed    int
+	skipped   int
+	retried   int
+}
+
+// total is the number of results counted
+func (c statCounts) total() int {
+	return c.succeeded + c.failed + c.skipped
+}
+
+// record counts result as skipped, failed or succeeded
+func (s *runStats) record(result CommandResult) {
+	switch {
+	case result.Skipped != "":
+		atomic.AddInt64(&s.skipped, 1)
+	case !result.Success:
+		atomic.AddInt64(&s.failed, 1)
+	default:
+		atomic.AddInt64(&s.succeeded, 1)
+	}
+	atomic.AddInt64(&s.retried, int64(result.retries))
+}
+
+// snapshot returns the current counts
+func (s *runStats) snapshot() statCounts {
+	return statCounts{
+		succeeded: int(atomic.LoadInt64(&s.succeeded)),
+		failed:    int(atomic.LoadInt64(&s.failed)),
+		skipped:   int(atomic.LoadInt64(&s.skipped)),
+		retried:   int(atomic.LoadInt64(&s.retried)),
+	}
+}
+
+// line is the single line printed at the end of a run for monitoring to
+// grep, such as "pgit: total=3 ok=2 failed=1 skipped=0 duration=1.2s".
+// Runs with retries also get "retried=N".
+func (c statCounts) line(elapsed time.Duration) string {
+	line := fmt.Sprintf("pgit: total=%d ok=%d failed=%d skipped=%d duration=%s",
+		c.total(), c.succeeded, c.failed, c.skipped, elapsed.Round(100*time.Millisecond))
+	if c.retried > 0 {
+		line += fmt.Sprintf(" retried=%d", c.retried)
+	}
+	return line
+}
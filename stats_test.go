@@ -0,0 +1,38 @@
+package main
+
+import (
+	"errors"
+	"sync"
+	"testing"
+)
+
+// TestRunStatsConcurrent records from many goroutines at once; run it with
+// -race to check that the counters are safe to share
+func TestRunStatsConcurrent(t *testing.T) {
+	stats := &runStats{}
+	results := []CommandResult{
+		{Success: true},
+		{Success: false, Error: errors.New("failed"), retries: 2},
+		{Success: true, Skipped: "clean"},
+	}
+
+	const perKind = 100
+	var wg sync.WaitGroup
+	for _, result := range results {
+		for i := 0; i < perKind; i++ {
+			wg.Add(1)
+			go func(result CommandResult) {
+				defer wg.Done()
+				stats.record(result)
+				stats.snapshot()
+			}(result)
+		}
+	}
+	wg.Wait()
+
+	got := stats.snapshot()
+	want := statCounts{succeeded: perKind, failed: perKind, skipped: perKind, retried: 2 * perKind}
+	if got != want {
+		t.Errorf("counts = %+v, want %+v", got, want)
+	}
+}
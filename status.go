@@ -0,0 +1,101 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+	"text/tabwriter"
+)
+
+// repoStatus is one row of the -status table
+type repoStatus struct {
+	Repo    string
+	Branch  string
+	Changes int
+	// Upstream is "+ahead -behind", or empty for branches without one
+	Upstream string
+	Err      error
+}
+
+// collectStatus gathers the branch, upstream counts and uncommitted
+// changes of each repo on up to limit workers, or one per repository when
+// limit is 0
+func collectStatus(repos []string, limit int) []repoStatus {
+	if limit <= 0 || limit > len(repos) {
+		limit = len(repos)
+	}
+	statuses := make([]repoStatus, len(repos))
+	input := make(chan int)
+	var workers sync.WaitGroup
+	for w := 0; w < limit; w++ {
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			for i := range input {
+				statuses[i] = repoStatusOf(repos[i])
+			}
+		}()
+	}
+	for i := range repos {
+		input <- i
+	}
+	close(input)
+	workers.Wait()
+	return statuses
+}
+
+func repoStatusOf(repo string) repoStatus {
+	dir := repoDir(repo)
+	status := repoStatus{Repo: repo, Branch: displayBranch(dir)}
+
+	porcelain, err := gitOutput(dir, "status", "--porcelain")
+	if err != nil {
+		status.Err = fmt.Errorf("could not check status: %s", err.Error())
+		return status
+	}
+	if porcelain != "" {
+		status.Changes = len(strings.Split(porcelain, "\n"))
+	}
+
+	ahead, behind, err := aheadBehind(dir)
+	if err == nil {
+		status.Upstream = fmt.Sprintf("+%d -%d", ahead, behind)
+	} else if err != errNoUpstream {
+		status.Err = fmt.Errorf("could not compare with upstream: %s", err.Error())
+	}
+	return status
+}
+
+// printStatus writes an aligned table of statuses, dirty repositories first
+// and then by name. It returns the number of repositories that could not be
+// checked.
+func printStatus(w io.Writer, statuses []repoStatus) int {
+	sort.SliceStable(statuses, func(i, j int) bool {
+		if (statuses[i].Changes > 0) != (statuses[j].Changes > 0) {
+			return statuses[i].Changes > 0
+		}
+		return statuses[i].Repo < statuses[j].Repo
+	})
+
+	failed := 0
+	table := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(table, "REPO\tBRANCH\tUPSTREAM\tSTATUS")
+	for _, status := range statuses {
+		upstream := status.Upstream
+		if upstream == "" {
+			upstream = "-"
+		}
+		state := "clean"
+		if status.Err != nil {
+			failed++
+			state = "error: " + status.Err.Error()
+		} else if status.Changes > 0 {
+			state = fmt.Sprintf("%d changed", status.Changes)
+		}
+		fmt.Fprintf(table, "%s\t%s\t%s\t%s\n", status.Repo, status.Branch, upstream, state)
+	}
+	table.Flush()
+	return failed
+}
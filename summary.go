@@ -0,0 +1,64 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"text/tabwriter"
+	"time"
+
+	"github.com/saquib.mian/pgit/runner"
+)
+
+// printSummary writes an aligned table with one row per result, sorted by
+// repository
+func printSummary(w io.Writer, results []CommandResult) {
+	sorted := make([]CommandResult, len(results))
+	copy(sorted, results)
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].Command.Repo < sorted[j].Command.Repo
+	})
+
+	table := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	if showBranch {
+		fmt.Fprintln(table, "REPO\tBRANCH\tSTATUS\tDURATION\tEXIT CODE")
+	} else {
+		fmt.Fprintln(table, "REPO\tSTATUS\tDURATION\tEXIT CODE")
+	}
+	for _, result := range sorted {
+		status := "OK"
+		if result.Skipped != "" {
+			status = fmt.Sprintf("skipped (%s)", result.Skipped)
+		} else if errors.As(result.Error, new(*runner.TimeoutError)) {
+			status = "TIMEOUT"
+		} else if !result.Success {
+			status = "FAIL"
+		}
+		repo := result.Command.Repo
+		if showBranch {
+			repo += "\t" + result.Branch
+		}
+		fmt.Fprintf(table, "%s\t%s\t%s\t%d\n", repo, status, result.Duration.Round(time.Millisecond), result.ExitCode)
+	}
+	table.Flush()
+}
+
+// printTimings writes the n slowest results, slowest first
+func printTimings(w io.Writer, results []CommandResult, n int) {
+	sorted := make([]CommandResult, len(results))
+	copy(sorted, results)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].Duration > sorted[j].Duration
+	})
+	if n < len(sorted) {
+		sorted = sorted[:n]
+	}
+
+	fmt.Fprintf(w, "slowest %d repositories:\n", len(sorted))
+	table := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	for _, result := range sorted {
+		fmt.Fprintf(table, "  %s\t%s\n", result.Command.Repo, result.Duration.Round(time.Millisecond))
+	}
+	table.Flush()
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"io"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// templateResult is the data a -template is executed with
+type templateResult struct {
+	Repo     string
+	Command  string
+	Step     int
+	Success  bool
+	Skipped  string
+	ExitCode int
+	Error    string
+	Duration time.Duration
+	Stdout   string
+	Stderr   string
+}
+
+// parseOutputTemplate parses a -template value
+func parseOutputTemplate(text string) (*template.Template, error) {
+	return template.New("result").Parse(text)
+}
+
+// writeTemplateResult renders result with tmpl, ending it with a newline if
+// the template does not
+func writeTemplateResult(w io.Writer, tmpl *template.Template, result CommandResult) error {
+	data := templateResult{
+		Repo:     result.Command.Repo,
+		Command:  result.Command.String(),
+		Step:     result.Step + 1,
+		Success:  result.Success,
+		Skipped:  result.Skipped,
+		ExitCode: result.ExitCode,
+		Duration: result.Duration,
+		Stdout:   result.Stdout,
+		Stderr:   result.Stderr,
+	}
+	if result.Error != nil {
+		data.Error = result.Error.Error()
+	}
+
+	var b strings.Builder
+	if err := tmpl.Execute(&b, data); err != nil {
+		return err
+	}
+	if !strings.HasSuffix(b.String(), "\n") {
+		b.WriteString("\n")
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
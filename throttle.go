@@ -0,0 +1,36 @@
+package main
+
+import (
+	"context"
+	"sync"
+)
+
+// hostLimiter bounds how many commands run at once against each remote host
+type hostLimiter struct {
+	limit int
+
+	mu    sync.Mutex
+	slots map[string]chan struct{}
+}
+
+// acquire blocks until a slot for host is free or ctx is done. The returned
+// function releases the slot.
+func (h *hostLimiter) acquire(ctx context.Context, host string) (func(), error) {
+	h.mu.Lock()
+	if h.slots == nil {
+		h.slots = map[string]chan struct{}{}
+	}
+	slot, ok := h.slots[host]
+	if !ok {
+		slot = make(chan struct{}, h.limit)
+		h.slots[host] = slot
+	}
+	h.mu.Unlock()
+
+	select {
+	case slot <- struct{}{}:
+		return func() { <-slot }, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
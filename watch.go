@@ -0,0 +1,167 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// watchDebounce is how long changes must settle before commands rerun
+const watchDebounce = 500 * time.Millisecond
+
+// watchRepos runs commands with runBatch, then again whenever a watched
+// repository changes, until parent is cancelled. A change while commands are
+// still running cancels them before starting over. Each repository's
+// working tree is watched, apart from what git ignores, along with the HEAD
+// and refs of its git directory.
+func watchRepos(parent context.Context, interrupted context.Context, commands [][]Command, skipped []CommandResult, report io.Writer) int {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		exitWithError(fmt.Errorf("could not watch repositories: %s", err.Error()))
+	}
+	defer watcher.Close()
+
+	for _, steps := range commands {
+		dir := repoDir(steps[0].Repo)
+		if err := watchTree(watcher, dir, ignoredPaths(dir)); err != nil {
+			warnf("could not watch all of %s: %s", steps[0].Repo, err.Error())
+		}
+		if gitdir, err := gitDir(dir); err == nil {
+			// HEAD moves on checkouts; branches and tags live under refs
+			watcher.Add(gitdir)
+			if err := watchTree(watcher, filepath.Join(commonDir(gitdir), "refs"), nil); err != nil {
+				warnf("could not watch the refs of %s: %s", steps[0].Repo, err.Error())
+			}
+		}
+	}
+
+	code := exitSuccess
+	for {
+		ctx, cancel := context.WithCancel(parent)
+		done := make(chan int, 1)
+		go func() {
+			code, _ := runBatch(ctx, interrupted, commands, skipped, report)
+			done <- code
+		}()
+
+		changed, ok := waitForChange(parent, watcher, func(path string) {
+			// directories created since are watched too
+			if err := watchTree(watcher, path, nil); err != nil {
+				warnf("could not watch %s: %s", path, err.Error())
+			}
+		})
+		cancel()
+		code = <-done
+		if !ok && interrupted.Err() != nil {
+			return exitInterrupted
+		} else if !ok {
+			return code
+		}
+
+		fmt.Fprintf(report, "\n--- %s changed, running again ---\n\n", changed)
+		branches.reset()
+	}
+}
+
+// waitForChange blocks until a watched path changes and no further changes
+// arrive for watchDebounce, returning the first changed path. New
+// directories are passed to created. It returns false once ctx is
+// cancelled.
+func waitForChange(ctx context.Context, watcher *fsnotify.Watcher, created func(path string)) (string, bool) {
+	changed := ""
+	var settled <-chan time.Time
+	for {
+		select {
+		case <-ctx.Done():
+			return "", false
+		case ev := <-watcher.Events:
+			if ignoredChange(ev.Name) {
+				continue
+			}
+			if ev.Op&fsnotify.Create != 0 {
+				if info, err := os.Stat(ev.Name); err == nil && info.IsDir() {
+					created(ev.Name)
+				}
+			}
+			if changed == "" {
+				changed = ev.Name
+			}
+			settled = time.After(watchDebounce)
+		case err := <-watcher.Errors:
+			warnf("watching failed: %s", err.Error())
+		case <-settled:
+			return changed, true
+		}
+	}
+}
+
+// watchTree watches dir and the directories below it, leaving out git
+// directories, nested repositories and the paths in ignored. It returns the
+// first error, carrying on past it.
+func watchTree(watcher *fsnotify.Watcher, dir string, ignored map[string]bool) error {
+	var first error
+	filepath.WalkDir(dir, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil || !entry.IsDir() {
+			return nil
+		}
+		if path != dir {
+			if entry.Name() == ".git" || ignored[path] {
+				return filepath.SkipDir
+			}
+			if _, err := os.Lstat(filepath.Join(path, ".git")); err == nil {
+				// another repository, watched if it is part of the run
+				return filepath.SkipDir
+			}
+		}
+		if err := watcher.Add(path); err != nil && first == nil {
+			first = err
+		}
+		return nil
+	})
+	return first
+}
+
+// ignoredPaths returns the directories of the repository at dir that git
+// ignores, such as build output, which are not worth watching
+func ignoredPaths(dir string) map[string]bool {
+	ignored := map[string]bool{}
+	out, err := gitOutput(dir, "ls-files", "-z", "--others", "--ignored", "--exclude-standard", "--directory")
+	if err != nil {
+		return ignored
+	}
+	for _, path := range strings.Split(out, "\x00") {
+		if strings.HasSuffix(path, "/") {
+			ignored[filepath.Join(dir, filepath.FromSlash(path))] = true
+		}
+	}
+	return ignored
+}
+
+// ignoredChange reports whether a change to path is inside a git directory
+// but not to HEAD or a ref. Everything else there, like the index and
+// FETCH_HEAD, is git's own bookkeeping, which commands do themselves.
+func ignoredChange(path string) bool {
+	parts := strings.Split(filepath.ToSlash(path), "/")
+	for i, part := range parts {
+		if part != ".git" {
+			continue
+		}
+		rest := parts[i+1:]
+		for len(rest) > 2 && (rest[0] == "worktrees" || rest[0] == "modules") {
+			// the git directory of a linked worktree or submodule
+			rest = rest[2:]
+		}
+		if strings.HasSuffix(path, ".lock") {
+			return true
+		}
+		return !(len(rest) == 1 && rest[0] == "HEAD") && !(len(rest) > 1 && rest[0] == "refs")
+	}
+	return false
+}
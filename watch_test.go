@@ -0,0 +1,32 @@
+package main
+
+import "testing"
+
+func TestIgnoredChange(t *testing.T) {
+	tests := []struct {
+		path string
+		want bool
+	}{
+		{"repo/src/main.go", false},
+		{"repo/README.md", false},
+		{"repo/build.lock", false},
+		{"repo/.git/HEAD", false},
+		{"repo/.git/refs/heads/main", false},
+		{"repo/.git/refs/tags/v1.0", false},
+		{"repo/.git/worktrees/linked/HEAD", false},
+		{"repo/.git/modules/lib/refs/heads/main", false},
+		{"repo/.git/index", true},
+		{"repo/.git/FETCH_HEAD", true},
+		{"repo/.git/ORIG_HEAD", true},
+		{"repo/.git/packed-refs", true},
+		{"repo/.git/objects/ab/cdef", true},
+		{"repo/.git/HEAD.lock", true},
+		{"repo/.git/refs/heads/main.lock", true},
+		{"repo/.git/worktrees/linked/index", true},
+	}
+	for _, test := range tests {
+		if got := ignoredChange(test.path); got != test.want {
+			t.Errorf("ignoredChange(%q) = %t, want %t", test.path, got, test.want)
+		}
+	}
+}